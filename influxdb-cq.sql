@@ -1,3 +1,5 @@
+# Continuous queries are replaced by rancher-catalog-stats -rollups, writing byPath_<interval>, byCatalog_<interval> and byCountry_<interval> measurements.
+# Drop the byCountry_* continuous queries before enabling it.
 # byIp agg 24h
 CREATE CONTINUOUS QUERY "byIp_24h" ON "catalog" RESAMPLE EVERY 6h BEGIN SELECT distinct("ip") AS ip INTO "byIp_24h" FROM "requests" WHERE "uid" != '-' GROUP BY time(24h),path,ip END
 # byUid_history agg 24h
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	clientGit     = "git"
+	clientHelm    = "helm"
+	clientRancher = "rancher"
+	clientBrowser = "browser"
+	clientBot     = "bot"
+	clientOther   = "other"
+)
+
+type reqClient struct {
+	Type           string `json:"type"`            // Client type (git, helm, rancher, browser, bot, other)
+	Version        string `json:"version"`         // Client version
+	RancherVersion string `json:"rancher_version"` // Rancher server version
+	OS             string `json:"os"`              // Client OS
+}
+
+type agentRule struct {
+	client string
+	regex  *regexp.Regexp
+}
+
+// User agent rules, first match wins. Version is the first submatch, if any
+var agentRules = []agentRule{
+	{clientBot, regexp.MustCompile(`(?i)(?:bot|crawler|spider|slurp|scanner|monitor|check)\b`)},
+	{clientRancher, regexp.MustCompile(`(?i)\brancher/v?([0-9][^ ;)]*)`)},
+	{clientHelm, regexp.MustCompile(`(?i)\bhelm/v?([0-9][^ ;)]*)`)},
+	{clientGit, regexp.MustCompile(`(?i)^(?:git|jgit|go-git)/v?([0-9][^ ;)]*)`)},
+	// Browsers based on chrome are checked before it, safari version is in Version/, not the webkit build in Safari/
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\bedg(?:e|a|ios)?/([0-9][^ ;)]*)`)},
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\b(?:opr|opera)/([0-9][^ ;)]*)`)},
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\b(?:firefox|fxios)/([0-9][^ ;)]*)`)},
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\b(?:chrome|chromium|crios)/([0-9][^ ;)]*)`)},
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\bversion/([0-9][^ ;)]*).*\bsafari/`)},
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\b(?:msie |trident/.*\brv:)([0-9][^ ;)]*)`)},
+	{clientBrowser, regexp.MustCompile(`(?i)^mozilla/.*\b(?:safari|msie|trident)\b`)},
+}
+
+// User agent OS, first match wins
+var agentOS = []struct {
+	os    string
+	regex *regexp.Regexp
+}{
+	{"android", regexp.MustCompile(`(?i)\bandroid\b`)},
+	{"ios", regexp.MustCompile(`(?i)\b(?:iphone|ipad|ios)\b`)},
+	{"windows", regexp.MustCompile(`(?i)\bwindows\b|\bwin(?:32|64)\b`)},
+	{"darwin", regexp.MustCompile(`(?i)\bmac ?os\b|\bdarwin\b|\bmacintosh\b`)},
+	{"freebsd", regexp.MustCompile(`(?i)\bfreebsd\b`)},
+	{"linux", regexp.MustCompile(`(?i)\blinux\b`)},
+}
+
+// Classify the user agent string
+func parseAgent(agent string) reqClient {
+	c := reqClient{Type: clientOther}
+
+	agent = strings.TrimSpace(agent)
+	for _, rule := range agentRules {
+		submatches := rule.regex.FindStringSubmatch(agent)
+		if submatches == nil {
+			continue
+		}
+		c.Type = rule.client
+		if len(submatches) > 1 {
+			c.Version = submatches[1]
+		}
+		break
+	}
+
+	if c.Type == clientRancher {
+		c.RancherVersion = c.Version
+	}
+
+	for _, o := range agentOS {
+		if o.regex.MatchString(agent) {
+			c.OS = o.os
+			break
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseAgent(t *testing.T) {
+	tests := []struct {
+		agent  string
+		client reqClient
+	}{
+		{"git/2.17.1", reqClient{Type: clientGit, Version: "2.17.1"}},
+		{"JGit/4.5.0.201609210915-r", reqClient{Type: clientGit, Version: "4.5.0.201609210915-r"}},
+		{"go-git/5.1.0", reqClient{Type: clientGit, Version: "5.1.0"}},
+		{"Helm/3.2.1", reqClient{Type: clientHelm, Version: "3.2.1"}},
+		{"Go-http-client/1.1 helm/v2.16.9", reqClient{Type: clientHelm, Version: "2.16.9"}},
+		{"rancher/v2.4.5 (linux/amd64)", reqClient{Type: clientRancher, Version: "2.4.5", RancherVersion: "2.4.5", OS: "linux"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.116 Safari/537.36", reqClient{Type: clientBrowser, Version: "83.0.4103.116", OS: "windows"}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:78.0) Gecko/20100101 Firefox/78.0", reqClient{Type: clientBrowser, Version: "78.0", OS: "darwin"}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Mobile/15E148 Safari/604.1", reqClient{Type: clientBrowser, Version: "13.1.1", OS: "ios"}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Safari/605.1.15", reqClient{Type: clientBrowser, Version: "13.1.1", OS: "darwin"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/84.0.4147.89 Safari/537.36 Edg/84.0.522.40", reqClient{Type: clientBrowser, Version: "84.0.522.40", OS: "windows"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.102 Safari/537.36 Edge/18.19041", reqClient{Type: clientBrowser, Version: "18.19041", OS: "windows"}},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.116 Safari/537.36 OPR/69.0.3686.77", reqClient{Type: clientBrowser, Version: "69.0.3686.77", OS: "linux"}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/84.0.4147.71 Mobile/15E148 Safari/604.1", reqClient{Type: clientBrowser, Version: "84.0.4147.71", OS: "ios"}},
+		{"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko", reqClient{Type: clientBrowser, Version: "11.0", OS: "windows"}},
+		{"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; Trident/6.0)", reqClient{Type: clientBrowser, Version: "10.0", OS: "windows"}},
+		{"Mozilla/5.0 (Linux; Android 10) AppleWebKit/537.36 (KHTML, like Gecko) Safari/537.36", reqClient{Type: clientBrowser, OS: "android"}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", reqClient{Type: clientBot}},
+		{"curl/7.64.1", reqClient{Type: clientOther}},
+		{"-", reqClient{Type: clientOther}},
+		{"", reqClient{Type: clientOther}},
+	}
+
+	for _, test := range tests {
+		if client := parseAgent(test.agent); client != test.client {
+			t.Errorf("parseAgent(%q) = %+v, want %+v", test.agent, client, test.client)
+		}
+	}
+}
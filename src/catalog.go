@@ -0,0 +1,107 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	operationGitRefs   = "git-refs"
+	operationGitUpload = "git-upload-pack"
+	operationGitDumb   = "git-dumb"
+	operationGitCommit = "git-commits"
+	operationHelmIndex = "helm-index"
+	operationHelmChart = "helm-chart"
+	operationHelmProv  = "helm-prov"
+	operationOther     = "other"
+)
+
+type reqCatalog struct {
+	Path         string `json:"path"`          // Normalized path, without query string
+	Catalog      string `json:"catalog"`       // Git repo or helm repo
+	Chart        string `json:"chart"`         // Helm chart name
+	ChartVersion string `json:"chart_version"` // Helm chart version
+	Operation    string `json:"operation"`     // Catalog operation
+}
+
+var (
+	// Git smart and dumb http endpoints
+	// Example: /rancher-catalog.git/info/refs, /rancher-catalog.git/git-upload-pack
+	gitSmartPath = regexp.MustCompile(`^/(.+?)(?:\.git)?/(info/refs|git-upload-pack)$`)
+	gitDumbPath  = regexp.MustCompile(`^/(.+?)\.git/(?:HEAD|objects/.*|refs/.*|packed-refs)$`)
+
+	// Github api branch commits, checked by rancher 2.x for catalog updates
+	// Example: /repos/rancher-catalog/commits/v2.0-release
+	gitCommitPath = regexp.MustCompile(`^/repos/([^/]+)/commits/[^/]+$`)
+
+	// Helm chart archive or provenance
+	// Example: /assets/rancher-monitoring/rancher-monitoring-100.1.0+up19.0.3.tgz
+	helmChartPath = regexp.MustCompile(`^(.+)-v?([0-9]+\.[0-9]+\.[0-9]+[^/]*)\.tgz(\.prov)?$`)
+)
+
+// Classify the request path into catalog operation
+func parseCatalog(host, p string) reqCatalog {
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	c := reqCatalog{Path: p, Operation: operationOther}
+
+	if submatches := gitSmartPath.FindStringSubmatch(p); submatches != nil {
+		c.Catalog = submatches[1]
+		c.Operation = operationGitRefs
+		if submatches[2] == operationGitUpload {
+			c.Operation = operationGitUpload
+		}
+		return c
+	}
+
+	if submatches := gitDumbPath.FindStringSubmatch(p); submatches != nil {
+		c.Catalog = submatches[1]
+		c.Operation = operationGitDumb
+		// Objects are normalized to keep path cardinality
+		c.Path = "/" + c.Catalog + ".git/" + operationGitDumb
+		return c
+	}
+
+	if submatches := gitCommitPath.FindStringSubmatch(p); submatches != nil {
+		c.Catalog = submatches[1]
+		c.Operation = operationGitCommit
+		return c
+	}
+
+	dir, file := path.Split(p)
+	if file == "index.yaml" {
+		c.Catalog = helmCatalog(host, dir)
+		c.Operation = operationHelmIndex
+		return c
+	}
+
+	if submatches := helmChartPath.FindStringSubmatch(file); submatches != nil {
+		c.Catalog = helmCatalog(host, dir)
+		c.Chart = submatches[1]
+		c.ChartVersion = submatches[2]
+		c.Operation = operationHelmChart
+		if len(submatches[3]) > 0 {
+			c.Operation = operationHelmProv
+		}
+		return c
+	}
+
+	// Unclassified paths, like scanner probes, are collapsed to keep path cardinality. Use raw_path to get them
+	c.Path = operationOther
+	return c
+}
+
+// Helm repo is the path dir, without the assets dir, or the host
+// Example: /server-charts/latest/, /assets/rancher-monitoring/
+func helmCatalog(host, dir string) string {
+	if i := strings.Index(dir, "/assets/"); i >= 0 {
+		dir = dir[:i]
+	}
+	dir = strings.Trim(dir, "/")
+	if len(dir) == 0 {
+		return host
+	}
+	return host + "/" + dir
+}
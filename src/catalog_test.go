@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseCatalog(t *testing.T) {
+	tests := []struct {
+		host    string
+		path    string
+		catalog reqCatalog
+	}{
+		{"git.rancher.io", "/rancher-catalog.git/info/refs?service=git-upload-pack", reqCatalog{Path: "/rancher-catalog.git/info/refs", Catalog: "rancher-catalog", Operation: operationGitRefs}},
+		{"git.rancher.io", "/charts/info/refs?service=git-upload-pack", reqCatalog{Path: "/charts/info/refs", Catalog: "charts", Operation: operationGitRefs}},
+		{"git.rancher.io", "/rancher-catalog.git/git-upload-pack", reqCatalog{Path: "/rancher-catalog.git/git-upload-pack", Catalog: "rancher-catalog", Operation: operationGitUpload}},
+		{"git.rancher.io", "/rancher-catalog.git/objects/ab/cdef0123", reqCatalog{Path: "/rancher-catalog.git/git-dumb", Catalog: "rancher-catalog", Operation: operationGitDumb}},
+		{"git.rancher.io", "/rancher-catalog.git/HEAD", reqCatalog{Path: "/rancher-catalog.git/git-dumb", Catalog: "rancher-catalog", Operation: operationGitDumb}},
+		{"git.rancher.io", "/repos/rancher-catalog/commits/v2.0-release", reqCatalog{Path: "/repos/rancher-catalog/commits/v2.0-release", Catalog: "rancher-catalog", Operation: operationGitCommit}},
+		{"releases.rancher.com", "/server-charts/latest/index.yaml", reqCatalog{Path: "/server-charts/latest/index.yaml", Catalog: "releases.rancher.com/server-charts/latest", Operation: operationHelmIndex}},
+		{"charts.rancher.io", "/index.yaml", reqCatalog{Path: "/index.yaml", Catalog: "charts.rancher.io", Operation: operationHelmIndex}},
+		{"releases.rancher.com", "/server-charts/latest/rancher-2.4.5.tgz", reqCatalog{Path: "/server-charts/latest/rancher-2.4.5.tgz", Catalog: "releases.rancher.com/server-charts/latest", Chart: "rancher", ChartVersion: "2.4.5", Operation: operationHelmChart}},
+		{"charts.rancher.io", "/assets/rancher-monitoring/rancher-monitoring-100.1.0+up19.0.3.tgz", reqCatalog{Path: "/assets/rancher-monitoring/rancher-monitoring-100.1.0+up19.0.3.tgz", Catalog: "charts.rancher.io", Chart: "rancher-monitoring", ChartVersion: "100.1.0+up19.0.3", Operation: operationHelmChart}},
+		{"charts.rancher.io", "/assets/longhorn/longhorn-v1.0.0.tgz.prov", reqCatalog{Path: "/assets/longhorn/longhorn-v1.0.0.tgz.prov", Catalog: "charts.rancher.io", Chart: "longhorn", ChartVersion: "1.0.0", Operation: operationHelmProv}},
+		{"git.rancher.io", "/wp-admin/setup-config.php?step=1", reqCatalog{Path: operationOther, Operation: operationOther}},
+		{"git.rancher.io", "/", reqCatalog{Path: operationOther, Operation: operationOther}},
+		{"git.rancher.io", "", reqCatalog{Path: operationOther, Operation: operationOther}},
+	}
+
+	for _, test := range tests {
+		if catalog := parseCatalog(test.host, test.path); catalog != test.catalog {
+			t.Errorf("parseCatalog(%q, %q) = %+v, want %+v", test.host, test.path, catalog, test.catalog)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+// Compressed log extensions
+var compressedExts = map[string]bool{
+	".gz":  true,
+	".bz2": true,
+	".zst": true,
+}
+
+func isCompressed(f string) bool {
+	return compressedExts[strings.ToLower(filepath.Ext(f))]
+}
+
+type compressedReader struct {
+	io.Reader
+	file *os.File
+	zstd *zstd.Decoder
+}
+
+func (c *compressedReader) Close() error {
+	if c.zstd != nil {
+		c.zstd.Close()
+	}
+	return c.file.Close()
+}
+
+// Open a compressed log file, decompressing by extension
+func openCompressed(f string) (io.ReadCloser, error) {
+	ext := strings.ToLower(filepath.Ext(f))
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compressedReader{file: file}
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("decompressing %s: %v", f, err)
+		}
+		c.Reader = gz
+	case ".bz2":
+		c.Reader = bzip2.NewReader(file)
+	case ".zst":
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("decompressing %s: %v", f, err)
+		}
+		c.Reader = zr
+		c.zstd = zr
+	default:
+		file.Close()
+		return nil, fmt.Errorf("unsupported compression %s", f)
+	}
+
+	return c, nil
+}
+
+// Get data from a compressed log file, batch mode. Offsets are from the decompressed data
+func (r *Requests) getDataByCompressedFile(f string, src reqSource) {
+	stop, data, ok := r.Control.Get(f)
+	if !ok {
+		log.Error("Getting reader channels ", f)
+		return
+	}
+
+	in, err := openCompressed(f)
+	if err != nil {
+		log.Error("[Error]: ", err)
+		return
+	}
+	defer in.Close()
+
+	log.Info("Analyzing compressed ", f)
+	defer log.Info("Closed file ", f)
+
+	if src.Offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, in, src.Offset); err != nil {
+			log.Errorf("Seeking file %s to offset %d: %v", f, src.Offset, err)
+			return
+		}
+	}
+
+	r.getDataByReader(in, src, stop, data)
+}
+
+// Get data from reader lines until EOF or stop. Lines are read apart, to stop while a pipe read blocks
+func (r *Requests) getDataByReader(in io.Reader, src reqSource, stop chan struct{}, data chan *Request) {
+	st := r.Status.file(src.File)
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadString('\n')
+			if len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				select {
+				case <-done:
+					// Closed on stop
+				default:
+					if err != io.EOF {
+						log.Errorf("Reading %s: %v", src.File, err)
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			src.Offset += int64(len(line))
+			st.setOffset(src.Offset)
+			r.getData(strings.TrimRight(line, "\r\n"), src, data)
+		}
+	}
+}
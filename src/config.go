@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	envPrefix     = "CATALOG_STATS_"
+	envFileSuffix = "_FILE"
+)
+
+// Params that shouldn't be passed on command line, as they show in ps
+var secretParams = []string{"anonkey", "influxpass", "influxtoken", "ingesttoken"}
+
+// Normalize a config key or env var name to a flag name, e.g. INFLUX_PASS to influxpass
+func configKey(s string) string {
+	s = strings.ToLower(s)
+	s = strings.Replace(s, "_", "", -1)
+	return strings.Replace(s, "-", "", -1)
+}
+
+// Get a flag by config key. A key with _file suffix reads the value from a file
+func configFlag(key string) (f *flag.Flag, secretFile bool) {
+	if f = flag.Lookup(configKey(key)); f != nil {
+		return f, false
+	}
+	suffix := strings.ToLower(envFileSuffix)
+	if strings.HasSuffix(strings.ToLower(key), suffix) {
+		if f = flag.Lookup(configKey(key[:len(key)-len(suffix)])); f != nil {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Read a secret from file, without trailing new line
+func readSecret(f string) (string, error) {
+	value, err := ioutil.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+// Set a flag if not set yet, by a higher precedence source
+func setParam(set map[string]bool, key, value, source string) error {
+	f, secretFile := configFlag(key)
+	if f == nil {
+		return fmt.Errorf("%s: unknown param %s", source, key)
+	}
+	if set[f.Name] {
+		return nil
+	}
+	if secretFile {
+		var err error
+		if value, err = readSecret(value); err != nil {
+			return fmt.Errorf("%s: %s: %v", source, key, err)
+		}
+	}
+	if err := f.Value.Set(value); err != nil {
+		return fmt.Errorf("%s: %s: %v", source, key, err)
+	}
+	set[f.Name] = true
+	return nil
+}
+
+// Set params not set on command line from CATALOG_STATS_* env vars, e.g. CATALOG_STATS_INFLUXPASS_FILE=/run/secrets/influxpass
+func setParamsByEnv(set map[string]bool) error {
+	for _, env := range os.Environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(kv[0], envPrefix) || len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimPrefix(kv[0], envPrefix)
+		// Other tools may share the prefix
+		if f, _ := configFlag(key); f == nil {
+			log.Warnf("Ignoring env %s, unknown param", kv[0])
+			continue
+		}
+		if err := setParam(set, key, kv[1], "env "+kv[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config file value, a scalar as written or a list joined by commas. Other values are an error, reported with their key
+type configValue struct {
+	value string
+	err   error
+}
+
+func (c *configValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.value); err == nil {
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		c.err = fmt.Errorf("expected a value or a list of values")
+		return nil
+	}
+	c.value = strings.Join(list, ",")
+	return nil
+}
+
+// Set params not set on command line nor env from a yaml config file, a mapping of params names to values
+func setParamsByConfig(set map[string]bool, f string) error {
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]configValue{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %v", f, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if values[key].err != nil {
+			return fmt.Errorf("%s: %s: %v", f, key, values[key].err)
+		}
+		if err := setParam(set, key, values[key].value, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Merge params by precedence, command line flags, env vars, config file and defaults
+func (p *Params) loadConfig() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	cmdline := make(map[string]bool, len(set))
+	for name := range set {
+		cmdline[name] = true
+	}
+
+	if err := setParamsByEnv(set); err != nil {
+		return err
+	}
+	if len(p.config) > 0 {
+		if err := setParamsByConfig(set, p.config); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range secretParams {
+		if cmdline[name] {
+			log.Warnf("Param %s is visible on command line, set %s%s%s instead", name, envPrefix, strings.ToUpper(name), envFileSuffix)
+		}
+	}
+
+	return nil
+}
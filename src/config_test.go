@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want map[string]string
+		err  bool
+	}{
+		{"", map[string]string{}, false},
+		{"# comment\ninfluxurl: http://localhost:8086 # comment\n", map[string]string{"influxurl": "http://localhost:8086"}, false},
+		{"anonkey: \"quoted # value\"\nlimit: 2000\n", map[string]string{"anonkey": "quoted # value", "limit": "2000"}, false},
+		{"anonkey: 0x1F\nsince: 2019-08-01\ndedup: yes\nrefresh: 1.50\n", map[string]string{"anonkey": "0x1F", "since": "2019-08-01", "dedup": "yes", "refresh": "1.50"}, false},
+		{"outputs: [influx, 'json:/var/log/requests.json']\n", map[string]string{"outputs": "influx,json:/var/log/requests.json"}, false},
+		{"outputs:\n  - influx\n  - stdout:json\n", map[string]string{"outputs": "influx,stdout:json"}, false},
+		{"influxpass:\n", map[string]string{"influxpass": ""}, false},
+		{"influx:\n  url: http://localhost:8086\n", nil, true},
+		{"outputs: [influx, [stdout]]\n", nil, true},
+		{"- influxurl\n", nil, true},
+		{"influxurl = http://localhost:8086\n", nil, true},
+		{"anonkey: \"unterminated\n", nil, true},
+	}
+
+	for _, test := range tests {
+		values := map[string]configValue{}
+		err := yaml.Unmarshal([]byte(test.yaml), &values)
+		for _, value := range values {
+			if err == nil {
+				err = value.err
+			}
+		}
+		if (err != nil) != test.err {
+			t.Errorf("yaml %q error %v, want error %v", test.yaml, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if len(values) != len(test.want) {
+			t.Errorf("yaml %q = %v, want %q", test.yaml, values, test.want)
+			continue
+		}
+		for key, value := range test.want {
+			if values[key].value != value {
+				t.Errorf("yaml %q %s = %q, want %q", test.yaml, key, values[key].value, value)
+			}
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secret, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "catalog-stats.yaml")
+	if err := ioutil.WriteFile(config, []byte(`# comment
+
+testcmdline: from-config
+testenv: from-config
+test_config: "from-config" # comment
+testsecret_file: `+secret+`
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]*string{}
+	for _, name := range []string{"testcmdline", "testenv", "testconfig", "testsecret", "testdefault"} {
+		params[name] = flag.String(name, "default", "")
+	}
+	os.Setenv(envPrefix+"TESTENV", "from-env")
+	os.Setenv(envPrefix+"TESTCMDLINE", "from-env")
+	defer os.Unsetenv(envPrefix + "TESTENV")
+	defer os.Unsetenv(envPrefix + "TESTCMDLINE")
+
+	*params["testcmdline"] = "from-cmdline"
+	set := map[string]bool{"testcmdline": true}
+	if err := setParamsByEnv(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := setParamsByConfig(set, config); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"testcmdline": "from-cmdline",
+		"testenv":     "from-env",
+		"testconfig":  "from-config",
+		"testsecret":  "from-file",
+		"testdefault": "default",
+	}
+	for name, value := range want {
+		if *params[name] != value {
+			t.Errorf("param %s = %q, want %q", name, *params[name], value)
+		}
+	}
+
+	tests := []string{
+		"unknownparam: value\n",
+		"testdefault value\n",
+		"testdefault = value\n",
+		"testdefault:\n  nested: value\n",
+		"testdefault_file: " + filepath.Join(dir, "missing") + "\n",
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(config, []byte(test), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := setParamsByConfig(map[string]bool{}, config); err == nil {
+			t.Errorf("config %q expected error", test)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Rejected line reasons
+const (
+	reasonMismatch       = "regex_mismatch"
+	reasonBadTimestamp   = "bad_timestamp"
+	reasonLocalhost      = "localhost_host"
+	reasonInvalidRequest = "invalid_request"
+	reasonBadSyslog      = "bad_syslog"
+	reasonBadIngest      = "bad_ingest"
+)
+
+// Log line rejected by the parser
+type parseError struct {
+	reason string
+	err    error
+}
+
+func (e *parseError) Error() string {
+	if e.err == nil {
+		return e.reason
+	}
+	return e.reason + ": " + e.err.Error()
+}
+
+func newParseError(reason string, err error) *parseError {
+	return &parseError{reason: reason, err: err}
+}
+
+type deadLetterRecord struct {
+	Time   time.Time `json:"time"`
+	File   string    `json:"file"`
+	Offset int64     `json:"offset"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+	Line   string    `json:"line"`
+}
+
+// DeadLetter counts rejected lines by reason, writing them as json lines to a file or stderr if set
+type DeadLetter struct {
+	sync.Mutex
+	out     io.WriteCloser
+	enc     *json.Encoder
+	counts  map[string]uint64
+	privacy *Privacy
+}
+
+func newDeadLetter(f string, privacy *Privacy) (*DeadLetter, error) {
+	var d = &DeadLetter{
+		counts: map[string]uint64{},
+	}
+	if privacy != nil && privacy.enabled() {
+		d.privacy = privacy
+	}
+
+	switch f {
+	case "":
+		return d, nil
+	case "-":
+		d.out = os.Stderr
+	default:
+		file, err := os.OpenFile(f, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("opening dead letter file %s: %v", f, err)
+		}
+		d.out = file
+	}
+	d.enc = json.NewEncoder(d.out)
+
+	return d, nil
+}
+
+func (d *DeadLetter) add(src reqSource, line string, err error) {
+	reason, message := reasonMismatch, err.Error()
+	if e, ok := err.(*parseError); ok {
+		reason, message = e.reason, ""
+		if e.err != nil {
+			message = e.err.Error()
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.counts[reason]++
+	log.Debugf("Rejected line %s, %v", src.File, err)
+
+	if d.enc == nil {
+		return
+	}
+	if d.privacy != nil {
+		line = d.privacy.redact(line)
+	}
+	record := deadLetterRecord{
+		Time:   time.Now().UTC(),
+		File:   src.File,
+		Offset: src.Offset,
+		Reason: reason,
+		Error:  message,
+		Line:   line,
+	}
+	if err := d.enc.Encode(record); err != nil {
+		log.Error("[Error]: Writing dead letter ", err)
+	}
+}
+
+// Get rejected lines by reason
+func (d *DeadLetter) Counts() map[string]uint64 {
+	d.Lock()
+	defer d.Unlock()
+
+	counts := make(map[string]uint64, len(d.counts))
+	for reason, count := range d.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+func (d *DeadLetter) String() string {
+	counts := d.Counts()
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, " ")
+}
+
+func (d *DeadLetter) Close() {
+	if d.out == nil || d.out == os.Stderr {
+		return
+	}
+	message := "Closing dead letter file..."
+	err := d.out.Close()
+	check(err, message)
+	log.Debug(message)
+}
@@ -5,8 +5,10 @@ go 1.12
 require (
 	github.com/hpcloud/tail v1.0.1-0.20180514194441-a1dbeea552b7
 	github.com/influxdata/influxdb1-client v0.0.0-20190809212627-fc22c7df067e
+	github.com/klauspost/compress v1.10.11
 	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/sirupsen/logrus v1.4.2
 	gopkg.in/fsnotify/fsnotify.v1 v1.4.7 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+const (
+	hllVersion      = 1
+	hllMinPrecision = 4
+	hllMaxPrecision = 16
+)
+
+// HLL is a HyperLogLog sketch counting distinct values. Sketches of the same precision are mergeable
+type HLL struct {
+	p   uint8
+	reg []uint8
+}
+
+func newHLL(p uint8) *HLL {
+	return &HLL{
+		p:   p,
+		reg: make([]uint8, 1<<p),
+	}
+}
+
+func checkHLLPrecision(p int) error {
+	if p < hllMinPrecision || p > hllMaxPrecision {
+		return fmt.Errorf("hll precision should be between %d and %d", hllMinPrecision, hllMaxPrecision)
+	}
+	return nil
+}
+
+// 64 bits hash, fnv-1a with murmur3 finalizer to spread bits
+func hllHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (h *HLL) add(s string) {
+	x := hllHash(s)
+	index := x >> (64 - h.p)
+	rho := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rho > h.reg[index] {
+		h.reg[index] = rho
+	}
+}
+
+// Estimate distinct values, linear counting for small cardinalities
+func (h *HLL) count() uint64 {
+	m := float64(len(h.reg))
+
+	var sum float64
+	zeros := 0
+	for _, r := range h.reg {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.reg) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(e + 0.5)
+}
+
+func (h *HLL) merge(o *HLL) error {
+	if h.p != o.p {
+		return fmt.Errorf("merging hll of precision %d and %d", h.p, o.p)
+	}
+	for index, r := range o.reg {
+		if r > h.reg[index] {
+			h.reg[index] = r
+		}
+	}
+	return nil
+}
+
+// Serialize as version, precision and registers
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, len(h.reg)+2)
+	data = append(data, hllVersion, h.p)
+	return append(data, h.reg...), nil
+}
+
+func (h *HLL) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != hllVersion {
+		return fmt.Errorf("unknown hll sketch version")
+	}
+	p := data[1]
+	if err := checkHLLPrecision(int(p)); err != nil {
+		return err
+	}
+	if len(data) != 2+1<<p {
+		return fmt.Errorf("hll sketch size %d doesn't match precision %d", len(data), p)
+	}
+	h.p = p
+	h.reg = append([]uint8{}, data[2:]...)
+	return nil
+}
+
+// Base64 serialized sketch
+func (h *HLL) String() string {
+	data, _ := h.MarshalBinary()
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func parseHLL(s string) (*HLL, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	h := &HLL{}
+	if err := h.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Merge base64 sketches, one by line, and write the merged estimate
+func mergeHLL(in io.Reader, out io.Writer) error {
+	var merged *HLL
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 1<<20), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		h, err := parseHLL(line)
+		if err != nil {
+			return fmt.Errorf("parsing sketch: %v", err)
+		}
+		if merged == nil {
+			merged = h
+			continue
+		}
+		if err := merged.merge(h); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if merged == nil {
+		return fmt.Errorf("no sketches to merge")
+	}
+
+	fmt.Fprintln(out, merged.count())
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Relative error bound, 4 standard errors
+func hllBound(p uint8) float64 {
+	return 4 * 1.04 / math.Sqrt(float64(uint64(1)<<p))
+}
+
+func hllAdd(h *HLL, from, to int) {
+	for n := from; n < to; n++ {
+		h.add(fmt.Sprintf("81.2.%d.%d", n/256, n%256))
+	}
+}
+
+func TestHLLCount(t *testing.T) {
+	tests := []struct {
+		p     uint8
+		count int
+	}{
+		{4, 100},
+		{10, 10},
+		{10, 1000},
+		{12, 0},
+		{12, 1},
+		{12, 5000},
+		{12, 100000},
+		{14, 100000},
+		{16, 200000},
+	}
+
+	for _, test := range tests {
+		h := newHLL(test.p)
+		hllAdd(h, 0, test.count)
+		// Duplicates don't count
+		hllAdd(h, 0, test.count/2)
+
+		count := float64(h.count())
+		if test.count == 0 {
+			if count != 0 {
+				t.Errorf("p %d: count %v, want 0", test.p, count)
+			}
+			continue
+		}
+		if e := math.Abs(count-float64(test.count)) / float64(test.count); e > hllBound(test.p) {
+			t.Errorf("p %d: count %v, want %d, relative error %.3f over %.3f", test.p, count, test.count, e, hllBound(test.p))
+		}
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	tests := []struct {
+		p1, p2 uint8
+		from   int // Second sketch values, overlapping the first from 0 to 10000
+		to     int
+		err    bool
+	}{
+		{12, 12, 5000, 20000, false},
+		{12, 12, 10000, 10000, false},
+		{14, 14, 0, 10000, false},
+		{12, 14, 0, 10000, true},
+	}
+
+	for _, test := range tests {
+		h1, h2 := newHLL(test.p1), newHLL(test.p2)
+		hllAdd(h1, 0, 10000)
+		hllAdd(h2, test.from, test.to)
+
+		err := h1.merge(h2)
+		if test.err {
+			if err == nil {
+				t.Errorf("merging p %d and p %d expected error", test.p1, test.p2)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("merging p %d and p %d error: %v", test.p1, test.p2, err)
+			continue
+		}
+
+		want := 10000
+		if test.to > want {
+			want = test.to
+		}
+		count := float64(h1.count())
+		if e := math.Abs(count-float64(want)) / float64(want); e > hllBound(test.p1) {
+			t.Errorf("merged count %v, want %d, relative error %.3f over %.3f", count, want, e, hllBound(test.p1))
+		}
+	}
+}
+
+func TestHLLMarshal(t *testing.T) {
+	h := newHLL(12)
+	hllAdd(h, 0, 5000)
+
+	parsed, err := parseHLL(h.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.p != h.p || !bytes.Equal(parsed.reg, h.reg) || parsed.count() != h.count() {
+		t.Errorf("parsed sketch p %d count %d, want p %d count %d", parsed.p, parsed.count(), h.p, h.count())
+	}
+
+	tests := []struct {
+		data []byte
+	}{
+		{nil},
+		{[]byte{hllVersion}},
+		{[]byte{hllVersion + 1, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{[]byte{hllVersion, 2, 0, 0, 0, 0}},
+		{[]byte{hllVersion, 4, 0, 0}},
+	}
+	for _, test := range tests {
+		if err := (&HLL{}).UnmarshalBinary(test.data); err == nil {
+			t.Errorf("UnmarshalBinary(%v) expected error", test.data)
+		}
+	}
+	if _, err := parseHLL("not base64!"); err == nil {
+		t.Errorf("parseHLL expected error")
+	}
+}
+
+func TestMergeHLL(t *testing.T) {
+	h1, h2 := newHLL(12), newHLL(12)
+	hllAdd(h1, 0, 3000)
+	hllAdd(h2, 2000, 6000)
+
+	tests := []struct {
+		in  string
+		err bool
+	}{
+		{h1.String() + "\n\n" + h2.String() + "\n", false},
+		{"", true},
+		{h1.String() + "\n" + newHLL(10).String() + "\n", true},
+		{"garbage\n", true},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := mergeHLL(strings.NewReader(test.in), &out)
+		if test.err {
+			if err == nil {
+				t.Errorf("mergeHLL(%q) expected error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mergeHLL(%q) error: %v", test.in, err)
+			continue
+		}
+		var count float64
+		fmt.Sscan(out.String(), &count)
+		if e := math.Abs(count-6000) / 6000; e > hllBound(12) {
+			t.Errorf("mergeHLL count %v, want 6000", count)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/influxdata/influxdb1-client"
@@ -9,33 +15,83 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-type Influx struct {
-	url     string
-	db      string
-	user    string
-	pass    string
-	cli     influx.Client
-	batch   influx.BatchPoints
-	timeout time.Duration
+const (
+	influxV1 = 1
+	influxV2 = 2
+
+	precisionSecond = "s"
+	precisionNano   = "ns"
+)
+
+var errInfluxDisconnected = errors.New("influx disconnected")
+
+// Influx write answered with an error status
+type writeError struct {
+	status string
+	code   int
+	body   string
 }
 
-func newInflux(u, d, us, pa string) *Influx {
-	var a = &Influx{
-		url:  u,
-		db:   d,
-		user: us,
-		pass: pa,
+func (e *writeError) Error() string {
+	return fmt.Sprintf("writing to influx, %s: %s", e.status, e.body)
+}
+
+// Check a write error is worth retrying, influx unreachable or failing.
+// Batches rejected by influx, e.g. field type conflict, auth or size, fail again if retried
+func isRetryable(err error) bool {
+	if e, ok := err.(*writeError); ok {
+		return e.code >= http.StatusInternalServerError || e.code == http.StatusTooManyRequests
 	}
+	return true
+}
 
-	a.timeout = time.Duration(10)
-	return a
+// Post line protocol to an influx write api
+func postLines(cli *http.Client, req *http.Request) error {
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return &writeError{status: resp.Status, code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return nil
 }
 
-func (i *Influx) Check(retry int) bool {
-	connected := i.Connect()
-	for index := 0; index < retry && !connected; index, connected = index+1, i.Connect() {
+// Writer is an influx backend, sharing batching logic in Requests.sendToInflux
+type Writer interface {
+	Check(retry int) bool
+	CheckConnect(interval int, stop chan struct{}) chan bool
+	Close()
+	sendToInflux(m []influx.Point, retry int) error
+	Latency() time.Duration
+}
+
+// Get the influx writer for the configured influx version
+func newWriter(p Params) Writer {
+	// Dedup ids and rollup partials are carried as nanoseconds, also by spooled points replayed by any writer
+	precision := precisionSecond
+	if p.dedup || len(p.rollups) > 0 {
+		precision = precisionNano
+	}
+
+	if p.influxversion == influxV2 {
+		return newInflux2(p.influxurl, p.influxorg, p.influxbucket, p.influxtoken, precision)
+	}
+	return newInflux(p.influxurl, p.influxdb, p.influxuser, p.influxpass, precision)
+}
+
+// Try to connect, retrying with increasing wait
+func checkRetry(connect func() bool, url string, retry int) bool {
+	connected := connect()
+	for index := 0; index < retry && !connected; index, connected = index+1, connect() {
 		if !connected {
 			wait := index + 1*5
+			atomic.AddUint64(&influxRetries, 1)
 			log.Error("Influx disconnected...")
 			log.Error("Reconnecting ", index+1, " of ", retry, "...")
 			log.Info("Waiting ", wait, " seconds before retry...")
@@ -44,14 +100,15 @@ func (i *Influx) Check(retry int) bool {
 	}
 
 	if !connected {
-		log.Error("Failed to connect to influx ", i.url)
+		log.Error("Failed to connect to influx ", url)
 		return false
 	}
 
 	return true
 }
 
-func (i *Influx) CheckConnect(interval int, stop chan struct{}) chan bool {
+// Check connection every interval seconds. Returned channel is closed if connection is lost
+func checkConnect(w Writer, interval int, stop chan struct{}) chan bool {
 	ticker := time.NewTicker(time.Second * time.Duration(interval))
 
 	connected := make(chan bool)
@@ -63,7 +120,7 @@ func (i *Influx) CheckConnect(interval int, stop chan struct{}) chan bool {
 			case <-ticker.C:
 				if !running {
 					running = true
-					if !i.Check(5) {
+					if !w.Check(5) {
 						close(connected)
 						return
 					}
@@ -78,6 +135,41 @@ func (i *Influx) CheckConnect(interval int, stop chan struct{}) chan bool {
 	return connected
 }
 
+type Influx struct {
+	url       string
+	db        string
+	user      string
+	pass      string
+	precision string
+	cli       influx.Client
+	hc        *http.Client
+	batch     influx.BatchPoints
+	timeout   time.Duration
+	latency   time.Duration
+}
+
+func newInflux(u, d, us, pa, pr string) *Influx {
+	var a = &Influx{
+		url:       u,
+		db:        d,
+		user:      us,
+		pass:      pa,
+		precision: pr,
+	}
+
+	a.timeout = time.Duration(10)
+	a.hc = &http.Client{Timeout: 10 * time.Second}
+	return a
+}
+
+func (i *Influx) Check(retry int) bool {
+	return checkRetry(i.Connect, i.url, retry)
+}
+
+func (i *Influx) CheckConnect(interval int, stop chan struct{}) chan bool {
+	return checkConnect(i, interval, stop)
+}
+
 func (i *Influx) Connect() bool {
 	var err error
 	if i.cli != nil {
@@ -116,6 +208,9 @@ func (i *Influx) Init() {
 }
 
 func (i *Influx) Close() {
+	if i.cli == nil {
+		return
+	}
 	message := "Closing Influx connection..."
 	err := i.cli.Close()
 	check(err, message)
@@ -143,7 +238,7 @@ func (i *Influx) newBatch() {
 	message := "Creating Influx batch..."
 	i.batch, err = influx.NewBatchPoints(influx.BatchPointsConfig{
 		Database:  i.db,
-		Precision: "s",
+		Precision: i.precision,
 	})
 	check(err, message)
 	log.Debug(message)
@@ -165,26 +260,47 @@ func (i *Influx) newPoints(m []influx.Point) {
 	}
 }
 
-func (i *Influx) Write() {
+func (i *Influx) Write() error {
 	start := time.Now()
 	log.Debug("Writing batch points...")
 
-	// Write the batch
-	err := i.cli.Write(i.batch)
+	// Write the batch by http, the client doesn't report the response status
+	lines := make([]string, 0, len(i.batch.Points()))
+	for _, pt := range i.batch.Points() {
+		lines = append(lines, pt.PrecisionString(i.precision))
+	}
+
+	params := url.Values{}
+	params.Set("db", i.db)
+	params.Set("precision", i.precision)
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(i.url, "/")+"/write?"+params.Encode(), strings.NewReader(strings.Join(lines, "\n")))
 	if err != nil {
-		log.Error("[Error]: ", err)
+		return err
+	}
+	if len(i.user) > 0 {
+		req.SetBasicAuth(i.user, i.pass)
+	}
 
+	if err = postLines(i.hc, req); err != nil {
+		return err
 	}
 
-	log.Debug("Time to write ", len(i.batch.Points()), " points: ", float64((time.Since(start))/time.Millisecond), "ms")
+	i.latency = time.Since(start)
+	log.Debug("Time to write ", len(i.batch.Points()), " points: ", float64(i.latency/time.Millisecond), "ms")
+	return nil
+}
+
+// Duration of the last successful write
+func (i *Influx) Latency() time.Duration {
+	return i.latency
 }
 
-func (i *Influx) sendToInflux(m []influx.Point, retry int) bool {
+func (i *Influx) sendToInflux(m []influx.Point, retry int) error {
 	if i.Check(retry) {
 		i.Init()
 		i.newPoints(m)
-		i.Write()
-		return true
+		return i.Write()
 	}
-	return false
+	return errInfluxDisconnected
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Influx2 writes points to the InfluxDB 2.x /api/v2/write api
+type Influx2 struct {
+	url       string
+	org       string
+	bucket    string
+	token     string
+	precision string
+	cli       *http.Client
+	batch     []string
+	timeout   time.Duration
+	latency   time.Duration
+}
+
+func newInflux2(u, o, b, t, pr string) *Influx2 {
+	var a = &Influx2{
+		url:       strings.TrimSuffix(u, "/"),
+		org:       o,
+		bucket:    b,
+		token:     t,
+		precision: pr,
+	}
+
+	a.timeout = time.Duration(10) * time.Second
+	a.cli = &http.Client{Timeout: a.timeout}
+	return a
+}
+
+func (i *Influx2) Check(retry int) bool {
+	return checkRetry(i.Connect, i.url, retry)
+}
+
+func (i *Influx2) CheckConnect(interval int, stop chan struct{}) chan bool {
+	return checkConnect(i, interval, stop)
+}
+
+func (i *Influx2) Connect() bool {
+	start := time.Now()
+	resp, err := i.cli.Get(i.url + "/health")
+	if err != nil {
+		log.Error("[Error]: ", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Error("[Error]: Influx health status ", resp.Status)
+		return false
+	}
+	log.Debug("Influx response time: ", time.Since(start))
+	return true
+}
+
+func (i *Influx2) Init() {
+	i.newBatch()
+}
+
+func (i *Influx2) Close() {
+	log.Debug("Closing Influx connection...")
+	i.cli.CloseIdleConnections()
+}
+
+func (i *Influx2) newBatch() {
+	log.Debug("Creating Influx batch...")
+	i.batch = []string{}
+}
+
+func (i *Influx2) newPoints(m []influx.Point) {
+	log.Debug("Adding ", len(m), " points to batch...")
+	for index := range m {
+		i.batch = append(i.batch, m[index].PrecisionString(i.precision))
+	}
+}
+
+func (i *Influx2) Write() error {
+	start := time.Now()
+	log.Debug("Writing batch points...")
+
+	params := url.Values{}
+	params.Set("org", i.org)
+	params.Set("bucket", i.bucket)
+	params.Set("precision", i.precision)
+
+	req, err := http.NewRequest("POST", i.url+"/api/v2/write?"+params.Encode(), strings.NewReader(strings.Join(i.batch, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+i.token)
+
+	if err = postLines(i.cli, req); err != nil {
+		return err
+	}
+
+	i.latency = time.Since(start)
+	log.Debug("Time to write ", len(i.batch), " points: ", float64(i.latency/time.Millisecond), "ms")
+	return nil
+}
+
+// Duration of the last successful write
+func (i *Influx2) Latency() time.Duration {
+	return i.latency
+}
+
+func (i *Influx2) sendToInflux(m []influx.Point, retry int) error {
+	if i.Check(retry) {
+		i.Init()
+		i.newPoints(m)
+		return i.Write()
+	}
+	return errInfluxDisconnected
+}
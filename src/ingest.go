@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ingestChannel = "ingest"
+	ingestMaxSize = 32 * 1024 * 1024
+)
+
+// Fluent Bit record log key, or Vector message key
+type ingestRecord struct {
+	Log     *string `json:"log"`
+	Message *string `json:"message"`
+}
+
+// Ingest receives log lines by http, feeding the writer while open
+type Ingest struct {
+	sync.RWMutex
+	data     chan *Request
+	handlers sync.WaitGroup
+}
+
+func (i *Ingest) open(data chan *Request) {
+	i.Lock()
+	i.data = data
+	i.Unlock()
+}
+
+// Stop accepting lines, waiting for running handlers before the writer channel is closed
+func (i *Ingest) close() {
+	i.Lock()
+	i.data = nil
+	i.Unlock()
+	i.handlers.Wait()
+}
+
+// Get the writer channel, adding a running handler if open
+func (i *Ingest) get() (chan *Request, bool) {
+	i.RLock()
+	defer i.RUnlock()
+	if i.data == nil {
+		return nil, false
+	}
+	i.handlers.Add(1)
+	return i.data, true
+}
+
+// Ingested line, or the error of a record without it
+type ingestLine struct {
+	text string
+	err  error
+}
+
+// Get lines from a raw or json body. The whole body is read before returning, so a body failing partway isn't partially fed.
+// Json is an array or a stream of records, raw lines are newline delimited
+func readIngestBody(body io.Reader, isJson bool) ([]ingestLine, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []ingestLine
+	switch {
+	case isJson && first == '[':
+		var records []ingestRecord
+		if err := json.NewDecoder(reader).Decode(&records); err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			lines = append(lines, rec.line())
+		}
+	case isJson:
+		dec := json.NewDecoder(reader)
+		for {
+			var rec ingestRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("record %d: %v", len(lines)+1, err)
+			}
+			lines = append(lines, rec.line())
+		}
+	default:
+		for {
+			line, err := reader.ReadString('\n')
+			line = strings.TrimRight(line, "\r\n")
+			if len(line) > 0 {
+				lines = append(lines, ingestLine{text: line})
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("line %d: %v", len(lines)+1, err)
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+func (rec ingestRecord) line() ingestLine {
+	switch {
+	case rec.Log != nil:
+		return ingestLine{text: strings.TrimRight(*rec.Log, "\r\n")}
+	case rec.Message != nil:
+		return ingestLine{text: strings.TrimRight(*rec.Message, "\r\n")}
+	}
+	return ingestLine{err: fmt.Errorf("record without log nor message key")}
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		reader.ReadByte()
+	}
+}
+
+// Receive log lines, newline delimited or Fluent Bit / Vector http output json
+func (r *Requests) serveIngest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(r.Config.ingestToken) > 0 {
+		auth := []byte(req.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+r.Config.ingestToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	data, ok := r.Ingest.get()
+	if !ok {
+		http.Error(w, "ingest closed", http.StatusServiceUnavailable)
+		return
+	}
+	defer r.Ingest.handlers.Done()
+
+	var body io.Reader = http.MaxBytesReader(w, req.Body, ingestMaxSize)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Limit the decompressed body too, as it's read whole
+		gzBody := http.MaxBytesReader(w, gz, ingestMaxSize)
+		defer gzBody.Close()
+		body = gzBody
+	}
+
+	host, _, _ := net.SplitHostPort(req.RemoteAddr)
+	src := reqSource{File: ingestChannel + "/" + host, Stream: true}
+
+	// Nothing is fed if the body can't be read whole, so the sender can retry it
+	isJson := strings.Contains(req.Header.Get("Content-Type"), "json")
+	lines, err := readIngestBody(body, isJson)
+	if err != nil {
+		log.Debugf("Reading ingest body from %s: %v", host, err)
+		http.Error(w, fmt.Sprintf("reading body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Offsets are from the request body. Records without line are dead lettered
+	rejected := 0
+	for _, line := range lines {
+		src.Offset += int64(len(line.text)) + 1
+		if line.err != nil {
+			rejected++
+			r.DeadLetter.add(src, line.text, newParseError(reasonBadIngest, line.err))
+			continue
+		}
+		r.getData(line.text, src, data)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"lines\":%d,\"rejected\":%d}\n", len(lines), rejected)
+}
+
+// Start ingest reader and writer, registered in the channel list as a file. The reader waits until stop
+func (r *Requests) getIngestReaders(in, out *sync.WaitGroup) {
+	stop, data, err := r.Control.Add(ingestChannel)
+	if err != nil {
+		log.Error("Creating control channels ", ingestChannel)
+		return
+	}
+	st := r.Status.file(ingestChannel)
+	r.Ingest.open(data)
+
+	in.Add(1)
+	go func() {
+		defer in.Done()
+		defer r.Control.Delete(ingestChannel)
+		log.Info("Accepting log lines on /ingest")
+		<-stop
+		r.Ingest.close()
+		log.Info("Closed ingest")
+	}()
+
+	out.Add(1)
+	go func() {
+		defer out.Done()
+		defer r.Status.remove(ingestChannel)
+		r.getOutput(data, st)
+	}()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type ingestErrReader struct{}
+
+func (ingestErrReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestReadIngestBody(t *testing.T) {
+	tests := []struct {
+		body   io.Reader
+		isJson bool
+		lines  []string // Empty for records without line
+		err    bool
+	}{
+		{strings.NewReader(""), false, nil, false},
+		{strings.NewReader("a\r\n\nb"), false, []string{"a", "b"}, false},
+		{io.MultiReader(strings.NewReader("a\nb"), ingestErrReader{}), false, nil, true},
+		{strings.NewReader(" \n "), true, nil, false},
+		{strings.NewReader(`[{"log": "a\n"}, {"message": "b"}, {"stream": "stdout"}]`), true, []string{"a", "b", ""}, false},
+		{strings.NewReader(`{"log": "a"} {"message": "b"}` + "\n" + `{"log": "c", "message": "d"}`), true, []string{"a", "b", "c"}, false},
+		{strings.NewReader(`[{"log": "a"}, {"log": "b"`), true, nil, true},
+		{strings.NewReader(`{"log": "a"}` + "\n" + `{"log": "b`), true, nil, true},
+		{strings.NewReader(`{"log": "a"} "b"`), true, nil, true},
+		{strings.NewReader(`{"log": "a"}`), false, []string{`{"log": "a"}`}, false},
+	}
+
+	for n, test := range tests {
+		lines, err := readIngestBody(test.body, test.isJson)
+		if (err != nil) != test.err {
+			t.Errorf("readIngestBody test %d: error %v, want error %v", n, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if len(lines) != len(test.lines) {
+			t.Errorf("readIngestBody test %d: got %d lines, want %d", n, len(lines), len(test.lines))
+			continue
+		}
+		for i, line := range lines {
+			if line.text != test.lines[i] || (line.err != nil) != (len(test.lines[i]) == 0) {
+				t.Errorf("readIngestBody test %d: line %d = %q error %v, want %q", n, i, line.text, line.err, test.lines[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// Inodes are not available, rotated files are not tracked
+func fileInode(fileInfo os.FileInfo) uint64 {
+	return 0
+}
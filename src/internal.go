@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+const internalMeasurement = "catalog_stats_internal"
+
+// Influx reconnection attempts, by all writers
+var influxRetries uint64
+
+// Pipeline counters since start, sent as internal stats
+type Internal struct {
+	hostname    string
+	read        uint64
+	dropped     uint64
+	geoipMisses uint64
+	parsed      map[string]*uint64 // By log format name, not modified after creation
+	written     uint64
+	rejected    uint64
+	writes      uint64
+	writeErrors uint64
+	writeTime   int64
+	lastLatency int64
+}
+
+func newInternal(formats []*LogFormat) *Internal {
+	n := &Internal{
+		parsed: map[string]*uint64{},
+	}
+	n.hostname, _ = os.Hostname()
+	for _, l := range formats {
+		n.parsed[l.Name] = new(uint64)
+	}
+	return n
+}
+
+func (n *Internal) addLine() {
+	atomic.AddUint64(&n.read, 1)
+}
+
+func (n *Internal) addDropped() {
+	atomic.AddUint64(&n.dropped, 1)
+}
+
+// Count a parsed request by log format, and geoip miss if not located
+func (n *Internal) addParsed(req *Request, geoip bool) {
+	if c, ok := n.parsed[req.Format]; ok {
+		atomic.AddUint64(c, 1)
+	}
+	if geoip && len(req.Location.Country.ISOCode) == 0 {
+		atomic.AddUint64(&n.geoipMisses, 1)
+	}
+}
+
+// Count an influx write, with the latency of a successful one
+func (n *Internal) addWrite(points int, latency time.Duration, err error) {
+	if err != nil {
+		atomic.AddUint64(&n.writeErrors, 1)
+		return
+	}
+	atomic.AddUint64(&n.writes, 1)
+	atomic.AddUint64(&n.written, uint64(points))
+	atomic.AddInt64(&n.writeTime, int64(latency))
+	atomic.StoreInt64(&n.lastLatency, int64(latency))
+}
+
+// Count points influx rejected
+func (n *Internal) addRejected(points int) {
+	atomic.AddUint64(&n.rejected, uint64(points))
+}
+
+func (n *Internal) Parsed() map[string]uint64 {
+	parsed := make(map[string]uint64, len(n.parsed))
+	for name, c := range n.parsed {
+		parsed[name] = atomic.LoadUint64(c)
+	}
+	return parsed
+}
+
+func (r *Requests) internalPoints() []influx.Point {
+	n := r.Internal
+	tags := map[string]string{
+		"hostname": n.hostname,
+	}
+
+	var rejected uint64
+	for _, c := range r.DeadLetter.Counts() {
+		rejected += c
+	}
+
+	var sinkDropped uint64
+	for _, c := range sinksDropped(r.Config.sinks) {
+		sinkDropped += c
+	}
+
+	var parsed uint64
+	fields := map[string]interface{}{
+		"lines_read":       int64(atomic.LoadUint64(&n.read)),
+		"lines_rejected":   int64(rejected),
+		"lines_dropped":    int64(atomic.LoadUint64(&n.dropped)),
+		"geoip_misses":     int64(atomic.LoadUint64(&n.geoipMisses)),
+		"points_written":   int64(atomic.LoadUint64(&n.written)),
+		"sink_dropped":     int64(sinkDropped),
+		"writes":           int64(atomic.LoadUint64(&n.writes)),
+		"write_errors":     int64(atomic.LoadUint64(&n.writeErrors)),
+		"write_retries":    int64(atomic.LoadUint64(&influxRetries)),
+		"write_time_ms":    float64(atomic.LoadInt64(&n.writeTime)) / float64(time.Millisecond),
+		"write_latency_ms": float64(atomic.LoadInt64(&n.lastLatency)) / float64(time.Millisecond),
+	}
+	for name, c := range n.Parsed() {
+		fields["lines_parsed_"+name] = int64(c)
+		parsed += c
+	}
+	fields["lines_parsed"] = int64(parsed)
+
+	return appendPoint(nil, internalMeasurement, tags, fields, time.Now())
+}
+
+// Send internal stats point
+func (r *Requests) flushInternal() {
+	points := r.internalPoints()
+	if len(points) == 0 {
+		return
+	}
+
+	if r.Config.preview {
+		fmt.Println(points[0].String())
+		return
+	}
+
+	log.Debug("Internal stats: Sending ", len(points), " points")
+	i := newWriter(r.Config)
+	defer i.Close()
+	if !r.send(i, points) {
+		log.Error("Internal stats: Failed to send ", len(points), " points")
+	}
+}
+
+// Expose internal counters on /metrics
+func (r *Requests) addInternalMetrics() {
+	n := r.Internal
+	r.Metrics.addCounters("catalog_lines_read_total", "Log lines read.", "", func() map[string]uint64 {
+		return map[string]uint64{"": atomic.LoadUint64(&n.read)}
+	})
+	r.Metrics.addCounters("catalog_parsed_lines_total", "Log lines parsed, by log format.", "log_format", n.Parsed)
+	r.Metrics.addCounters("catalog_dropped_lines_total", "Log lines dropped by rules.", "", func() map[string]uint64 {
+		return map[string]uint64{"": atomic.LoadUint64(&n.dropped)}
+	})
+	r.Metrics.addCounters("catalog_geoip_misses_total", "Requests without geoip location.", "", func() map[string]uint64 {
+		return map[string]uint64{"": atomic.LoadUint64(&n.geoipMisses)}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	logFormatAuto   = "auto"
+	logFormatV1     = "v1"
+	logFormatV2     = "v2"
+	logFormatCustom = "custom"
+)
+
+// Nginx log_format presets
+var logFormatPresets = map[string]string{
+	// Log format V2 with Cloudfare info
+	//        log_format main '[$time_local] $http_host $remote_addr $http_x_forwarded_for, $proxy_address '
+	//                        '"$request" $status $body_bytes_sent "$http_referer" '
+	//                        '"$http_user_agent" $request_time $upstream_response_time "$http_x_install_uuid"';
+	logFormatV2: `[$time_local] $http_host $remote_addr $http_x_forwarded_for, $proxy_address "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time $upstream_response_time "$http_x_install_uuid"`,
+
+	// Log format V1 direct connection
+	//        log_format main '[$time_local] $http_host $remote_addr $http_x_forwarded_for '
+	//                        '"$request" $status $body_bytes_sent "$http_referer" '
+	//                        '"$http_user_agent" $request_time $upstream_response_time "$http_x_install_uuid"';
+	logFormatV1: `[$time_local] $http_host $remote_addr $http_x_forwarded_for "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time $upstream_response_time "$http_x_install_uuid"`,
+}
+
+// Presets tried in order when log format is auto
+var logFormatAutoOrder = []string{logFormatV2, logFormatV1}
+
+var logFormatVar = regexp.MustCompile(`\$(?:\{([a-zA-Z0-9_]+)\}|([a-zA-Z0-9_]+))`)
+
+type LogFormat struct {
+	Name   string   // Preset name or custom
+	Fields []string // Nginx variable names, by submatch index - 1
+	regex  *regexp.Regexp
+}
+
+// Compile a nginx log_format string into a log line parser
+// Example: [$time_local] $http_host $remote_addr "$request" $status
+func newLogFormat(name, format string) (*LogFormat, error) {
+	format = unquoteLogFormat(format)
+	if len(format) == 0 {
+		return nil, fmt.Errorf("log format %s is empty", name)
+	}
+
+	l := &LogFormat{Name: name}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	matches := logFormatVar.FindAllStringSubmatchIndex(format, -1)
+	last := 0
+	for _, m := range matches {
+		expr.WriteString(regexp.QuoteMeta(format[last:m[0]]))
+		last = m[1]
+
+		var field string
+		if m[2] >= 0 {
+			field = format[m[2]:m[3]]
+		} else {
+			field = format[m[4]:m[5]]
+		}
+		l.Fields = append(l.Fields, field)
+
+		var next byte
+		if last < len(format) {
+			next = format[last]
+		}
+		expr.WriteString(logFormatVarRegex(next))
+	}
+	expr.WriteString(regexp.QuoteMeta(format[last:]))
+
+	if err := l.checkFields(); err != nil {
+		return nil, fmt.Errorf("log format %s: %v", name, err)
+	}
+
+	var err error
+	l.regex, err = regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("log format %s: %v", name, err)
+	}
+
+	return l, nil
+}
+
+// Get the log formats to try, in order. Accepted values are auto, a preset name or a nginx log_format string
+func newLogFormats(s string) ([]*LogFormat, error) {
+	var names []string
+	switch s {
+	case "", logFormatAuto:
+		names = logFormatAutoOrder
+	default:
+		if _, ok := logFormatPresets[s]; !ok {
+			l, err := newLogFormat(logFormatCustom, s)
+			if err != nil {
+				return nil, err
+			}
+			return []*LogFormat{l}, nil
+		}
+		names = []string{s}
+	}
+
+	formats := make([]*LogFormat, 0, len(names))
+	for _, name := range names {
+		l, err := newLogFormat(name, logFormatPresets[name])
+		if err != nil {
+			return nil, err
+		}
+		formats = append(formats, l)
+	}
+
+	return formats, nil
+}
+
+// Match the log line, returning nil if it doesn't
+func (l *LogFormat) match(str string) []string {
+	submatches := l.regex.FindStringSubmatch(str)
+	if len(submatches) != len(l.Fields)+1 {
+		return nil
+	}
+	return submatches[1:]
+}
+
+func (l *LogFormat) hasField(fields ...string) bool {
+	for _, f := range l.Fields {
+		for _, field := range fields {
+			if f == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check the log format has the variables needed to build a request
+func (l *LogFormat) checkFields() error {
+	if !l.hasField("time_local", "time_iso8601") {
+		return fmt.Errorf("$time_local or $time_iso8601 is required")
+	}
+	if !l.hasField("http_host", "host") {
+		return fmt.Errorf("$http_host or $host is required")
+	}
+	if !l.hasField("remote_addr", "http_x_forwarded_for") {
+		return fmt.Errorf("$remote_addr or $http_x_forwarded_for is required")
+	}
+	if !l.hasField("request") && !l.hasField("request_uri", "uri") {
+		return fmt.Errorf("$request or $request_uri is required")
+	}
+	return nil
+}
+
+// Regex for a variable, delimited by the next literal char
+func logFormatVarRegex(next byte) string {
+	switch next {
+	case '"':
+		return `([^"]*)`
+	case ']':
+		return `([^\]]+)`
+	}
+	return `([^ ]+)`
+}
+
+// Join a log_format copied from nginx config, '...' '...';
+func unquoteLogFormat(format string) string {
+	format = strings.TrimSpace(format)
+	format = strings.TrimSuffix(format, ";")
+	if !strings.HasPrefix(format, "'") {
+		return format
+	}
+
+	// Odd chunks are quoted, even chunks are separators
+	var joined strings.Builder
+	chunks := strings.Split(format, "'")
+	for i := 1; i < len(chunks); i += 2 {
+		joined.WriteString(chunks[i])
+	}
+	return joined.String()
+}
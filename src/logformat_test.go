@@ -0,0 +1,205 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewLogFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		fields []string
+		err    bool
+	}{
+		{`[$time_local] $http_host $remote_addr "$request"`, []string{"time_local", "http_host", "remote_addr", "request"}, false},
+		{`${time_iso8601} ${host} ${http_x_forwarded_for} "${request_uri}"`, []string{"time_iso8601", "host", "http_x_forwarded_for", "request_uri"}, false},
+		{`'[$time_local] $http_host ' '$remote_addr "$request"';`, []string{"time_local", "http_host", "remote_addr", "request"}, false},
+		{``, nil, true},
+		{`$http_host $remote_addr "$request"`, nil, true},
+		{`[$time_local] $remote_addr "$request"`, nil, true},
+		{`[$time_local] $http_host "$request"`, nil, true},
+		{`[$time_local] $http_host $remote_addr $status`, nil, true},
+	}
+
+	for _, test := range tests {
+		l, err := newLogFormat(logFormatCustom, test.format)
+		if test.err {
+			if err == nil {
+				t.Errorf("newLogFormat(%q) expected error", test.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newLogFormat(%q) error: %v", test.format, err)
+			continue
+		}
+		if len(l.Fields) != len(test.fields) {
+			t.Errorf("newLogFormat(%q) fields %v, want %v", test.format, l.Fields, test.fields)
+			continue
+		}
+		for index := range l.Fields {
+			if l.Fields[index] != test.fields[index] {
+				t.Errorf("newLogFormat(%q) fields %v, want %v", test.format, l.Fields, test.fields)
+				break
+			}
+		}
+	}
+}
+
+func TestLogFormatMatch(t *testing.T) {
+	l, err := newLogFormat(logFormatCustom, `[$time_local] $http_host $remote_addr "$request" $status "$http_user_agent"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line       string
+		submatches []string
+	}{
+		{`[21/Mar/2016:02:33:29 +0000] git.rancher.io 81.2.69.142 "GET / HTTP/1.1" 200 "git/2.17.1"`, []string{"21/Mar/2016:02:33:29 +0000", "git.rancher.io", "81.2.69.142", "GET / HTTP/1.1", "200", "git/2.17.1"}},
+		{`[21/Mar/2016:02:33:29 +0000] git.rancher.io 81.2.69.142 "" 400 ""`, []string{"21/Mar/2016:02:33:29 +0000", "git.rancher.io", "81.2.69.142", "", "400", ""}},
+		{`[21/Mar/2016:02:33:29 +0000] git.rancher.io 81.2.69.142 "GET / HTTP/1.1" 200`, nil},
+		{`garbage`, nil},
+	}
+
+	for _, test := range tests {
+		submatches := l.match(test.line)
+		if len(submatches) != len(test.submatches) {
+			t.Errorf("match(%q) = %q, want %q", test.line, submatches, test.submatches)
+			continue
+		}
+		for index := range submatches {
+			if submatches[index] != test.submatches[index] {
+				t.Errorf("match(%q) = %q, want %q", test.line, submatches, test.submatches)
+				break
+			}
+		}
+	}
+}
+
+func TestParsePresets(t *testing.T) {
+	tests := []struct {
+		logFormat string
+		line      string
+		format    string
+		ip        string
+		uid       string
+	}{
+		{logFormatAuto, benchLine, logFormatV2, "81.2.69.142", "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f"},
+		{logFormatV2, benchLine, logFormatV2, "81.2.69.142", "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f"},
+		{logFormatAuto, `[21/Mar/2016:02:33:29 +0000] git.rancher.io 81.2.69.142 - "GET /rancher-catalog.git/info/refs?service=git-upload-pack HTTP/1.1" 200 1234 "-" "git/2.17.1" 0.010 0.010 "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f"`, logFormatV1, "81.2.69.142", "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f"},
+		{logFormatV1, `[21/Mar/2016:02:33:29 +0000] git.rancher.io 10.0.0.1 81.2.69.142 "GET /rancher-catalog.git/info/refs?service=git-upload-pack HTTP/1.1" 200 1234 "-" "git/2.17.1" 0.010 0.010 "-"`, logFormatV1, "81.2.69.142", "-"},
+	}
+
+	ts := time.Date(2016, time.March, 21, 2, 33, 29, 0, time.UTC)
+	for _, test := range tests {
+		formats, err := newLogFormats(test.logFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := newParser(formats, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := p.parse(test.line)
+		if err != nil {
+			t.Errorf("%s: parse(%q) error: %v", test.logFormat, test.line, err)
+			continue
+		}
+		if req.Format != test.format {
+			t.Errorf("%s: parse(%q) format %s, want %s", test.logFormat, test.line, req.Format, test.format)
+		}
+		if !req.Timestamp.Equal(ts) {
+			t.Errorf("%s: parse(%q) timestamp %v, want %v", test.logFormat, test.line, req.Timestamp, ts)
+		}
+		if req.Host != "git.rancher.io" || req.Method != "GET" || req.Status != "200" || req.Agent != "git/2.17.1" {
+			t.Errorf("%s: parse(%q) host %s, method %s, status %s, agent %s", test.logFormat, test.line, req.Host, req.Method, req.Status, req.Agent)
+		}
+		if req.Ip != test.ip {
+			t.Errorf("%s: parse(%q) ip %q, want %q", test.logFormat, test.line, req.Ip, test.ip)
+		}
+		if req.Uid != test.uid {
+			t.Errorf("%s: parse(%q) uid %q, want %q", test.logFormat, test.line, req.Uid, test.uid)
+		}
+		if req.Catalog.Operation != operationGitRefs {
+			t.Errorf("%s: parse(%q) operation %s, want %s", test.logFormat, test.line, req.Catalog.Operation, operationGitRefs)
+		}
+	}
+}
+
+func TestParseRejected(t *testing.T) {
+	formats, err := newLogFormats(logFormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newParser(formats, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line   string
+		reason string
+	}{
+		{`garbage`, reasonMismatch},
+		{`[21/Mar/2016:02:33:29 +0000] localhost 10.0.0.1 81.2.69.142, 10.0.0.2 "GET / HTTP/1.1" 200 1234 "-" "curl" 0.010 0.010 "-"`, reasonLocalhost},
+		{`[21/Foo/2016:02:33:29 +0000] git.rancher.io 10.0.0.1 81.2.69.142, 10.0.0.2 "GET / HTTP/1.1" 200 1234 "-" "curl" 0.010 0.010 "-"`, reasonBadTimestamp},
+	}
+
+	for _, test := range tests {
+		_, err := p.parse(test.line)
+		e, ok := err.(*parseError)
+		if !ok {
+			t.Errorf("parse(%q) error %v, want %s", test.line, err, test.reason)
+			continue
+		}
+		if e.reason != test.reason {
+			t.Errorf("parse(%q) reason %s, want %s", test.line, e.reason, test.reason)
+		}
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	formats, err := newLogFormats(logFormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const prefix = `[21/Mar/2016:02:33:29 +0000] git.rancher.io 10.0.0.1 81.2.69.142 `
+	tests := []struct {
+		line    string
+		strict  bool
+		invalid string // Invalid tag if kept
+		reason  string // Rejection reason if not kept
+	}{
+		{prefix + `"GET / HTTP/1.1" 200 1234 "-" "git/2.17.1" 0.010 0.010 "-"`, true, "", ""},
+		{prefix + `"" 400 0 "-" "-" 0.000 - "-"`, false, reasonInvalidRequest, ""},
+		{prefix + `"" 400 0 "-" "-" 0.000 - "-"`, true, "", reasonInvalidRequest},
+		{prefix + `"\x16\x03\x01" 400 0 "-" "-" 0.000 - "-"`, false, reasonInvalidRequest, ""},
+		{prefix + `"\x16\x03\x01" 400 0 "-" "-" 0.000 - "-"`, true, "", reasonInvalidRequest},
+	}
+
+	for _, test := range tests {
+		p, err := newParser(formats, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.strict = test.strict
+
+		req, err := p.parse(test.line)
+		if len(test.reason) > 0 {
+			if e, ok := err.(*parseError); !ok || e.reason != test.reason {
+				t.Errorf("strict %v: parse(%q) error %v, want %s", test.strict, test.line, err, test.reason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("strict %v: parse(%q) error: %v", test.strict, test.line, err)
+			continue
+		}
+		if req.Invalid != test.invalid {
+			t.Errorf("strict %v: parse(%q) invalid %q, want %q", test.strict, test.line, req.Invalid, test.invalid)
+		}
+	}
+}
@@ -1,11 +1,24 @@
 package main
 
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
 func main() {
 	var params Params
 
 	params.init()
 
+	if params.hllMerge {
+		if err := mergeHLL(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	req := newRequests(params)
+	defer req.Close()
 	req.getDataByFiles()
-
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
 	"time"
 
@@ -9,8 +11,12 @@ import (
 )
 
 const (
-	formatJson   = "json"
-	formatInflux = "influx"
+	formatJson       = "json"
+	formatInflux     = "influx"
+	formatPrometheus = "prometheus"
+
+	validationStrict  = "strict"
+	validationLenient = "lenient"
 )
 
 func check(e error, m string) {
@@ -20,31 +26,108 @@ func check(e error, m string) {
 }
 
 type Params struct {
-	influxurl  string
-	influxdb   string
-	influxuser string
-	influxpass string
-	geoipdb    string
-	format     string
-	limit      int
-	filesPath  string
-	filesOld   string
-	refresh    int
-	daemon     bool
-	debug      bool
-	poll       bool
-	preview    bool
+	config          string
+	influxurl       string
+	influxdb        string
+	influxuser      string
+	influxpass      string
+	influxorg       string
+	influxbucket    string
+	influxtoken     string
+	influxversion   int
+	geoipdb         string
+	format          string
+	outputs         string
+	sinks           []*Sink
+	listen          string
+	syslog          string
+	syslogListeners []syslogListener
+	ingest          bool
+	ingestToken     string
+	logFormat       string
+	logFormats      []*LogFormat
+	rollups         string
+	rollupUnique    string
+	instance        string
+	hllPrecision    int
+	hllMerge        bool
+	measurement     string
+	tags            string
+	fields          string
+	schema          *Schema
+	anonymize       string
+	anonKey         string
+	hashUid         bool
+	saltRotate      string
+	privacy         *Privacy
+	limit           int
+	filesPath       string
+	filesOld        string
+	since           string
+	until           string
+	sinceTime       time.Time
+	untilTime       time.Time
+	backfill        bool
+	dedup           bool
+	spoolDir        string
+	stateFile       string
+	rulesFile       string
+	deadLetter      string
+	validation      string
+	tsFallback      bool
+	internalStats   bool
+	spoolMax        int
+	refresh         int
+	daemon          bool
+	debug           bool
+	poll            bool
+	preview         bool
 }
 
 func (p *Params) init() {
+	flag.StringVar(&p.config, "config", "", "Yaml config file, a mapping of params names to values. Params are overridden by "+envPrefix+"<PARAM> env vars, and those by command line")
 	flag.BoolVar(&p.debug, "debug", false, "Debug mode")
-	flag.StringVar(&p.format, "format", formatInflux, "Output format. "+formatInflux+" | "+formatJson)
+	flag.StringVar(&p.format, "format", formatInflux, "Output format. "+formatInflux+" | "+formatJson+" | "+formatPrometheus)
+	flag.StringVar(&p.outputs, "outputs", "", "Output sinks, comma separated, fed at once. "+sinkInflux+" | "+sinkPrometheus+" | "+sinkStdout+"[:"+formatInflux+"|"+formatJson+"] | "+sinkJson+":<file>. Set by format if empty")
+	flag.StringVar(&p.listen, "listen", "", "Http listen address to expose /metrics, /status, /healthz and /readyz, e.g. :9100. daemon mode")
+	flag.StringVar(&p.syslog, "syslog", "", "Syslog listen addresses to receive nginx logs, comma separated, e.g. udp://:5514,tcp://:5514. daemon mode")
+	flag.BoolVar(&p.ingest, "ingest", false, "Accept log lines posted to /ingest, newline delimited or Fluent Bit / Vector http output json. daemon mode, requires listen")
+	flag.StringVar(&p.ingestToken, "ingesttoken", "", "Bearer token required to post to /ingest. Disabled if empty")
 	flag.StringVar(&p.influxurl, "influxurl", "http://localhost:8086", "Influx url connection")
 	flag.StringVar(&p.influxdb, "influxdb", "", "Influx db name")
 	flag.StringVar(&p.influxuser, "influxuser", "", "Influx username")
 	flag.StringVar(&p.influxpass, "influxpass", "", "Influx password")
-	flag.StringVar(&p.filesPath, "filepath", "/var/log/nginx/access.log", "Log files to analyze, wildcard allowed between quotes")
+	flag.IntVar(&p.influxversion, "influxversion", influxV1, "Influx api version. 1 | 2")
+	flag.StringVar(&p.influxorg, "influxorg", "", "Influx organization. influx version 2")
+	flag.StringVar(&p.influxbucket, "influxbucket", "", "Influx bucket. influx version 2")
+	flag.StringVar(&p.influxtoken, "influxtoken", "", "Influx auth token. influx version 2")
+	flag.StringVar(&p.rollups, "rollups", "", "Rollup intervals aggregated in process and written as byPath_<interval>, byCatalog_<interval> and byCountry_<interval> measurements, comma separated, e.g. 1h,24h. Disabled if empty")
+	flag.StringVar(&p.instance, "instance", "", "Rollup instance tag, distinct for every daemon writing rollups. Hostname if empty")
+	flag.StringVar(&p.rollupUnique, "rollupunique", uniqueExact, "Rollup unique ips and uids counting. "+uniqueExact+" | "+uniqueHLL+" (estimated, also writes mergeable ip_sketch and uid_sketch fields)")
+	flag.IntVar(&p.hllPrecision, "hllprecision", 12, "Hll precision, 2^precision bytes by sketch, standard error 1.04/sqrt(2^precision)")
+	flag.BoolVar(&p.hllMerge, "hllmerge", false, "Merge base64 hll sketches read from stdin, one by line, print the unique estimate and exit")
+	flag.StringVar(&p.measurement, "measurement", schemaMeasurement, "Influx measurement name")
+	flag.StringVar(&p.tags, "tags", schemaTags, "Request attributes written as influx tags, comma separated. Attributes not in tags nor fields are dropped")
+	flag.StringVar(&p.fields, "fields", schemaFields, "Request attributes written as influx fields, comma separated. At least one is required")
+	flag.StringVar(&p.anonymize, "anonymize", anonymizeNone, "Anonymize client ips, after geoip lookup. "+anonymizeNone+" | "+anonymizeTruncate+" (/24 IPv4, /48 IPv6) | "+anonymizeHmac+" (keyed by anonkey)")
+	flag.StringVar(&p.anonKey, "anonkey", "", "Secret key for ip hmac and uid hashing")
+	flag.BoolVar(&p.hashUid, "hashuid", false, "Hash uids keyed by anonkey and a salt rotated every saltrotate")
+	flag.StringVar(&p.saltRotate, "saltrotate", "720h", "Uid hashing salt rotation by request time, 0 to not rotate")
+	flag.StringVar(&p.filesPath, "filepath", "/var/log/nginx/access.log", "Log files to analyze, wildcard allowed between quotes, - for stdin. Named pipes are read as streams")
 	flag.StringVar(&p.filesOld, "fileold", "1h", "Log files with modification time older than that, will be discarded")
+	flag.StringVar(&p.since, "since", "", "Discard requests before that time, RFC3339, date (2006-01-02) or duration ago (24h)")
+	flag.StringVar(&p.until, "until", "", "Discard requests from that time, RFC3339, date (2006-01-02) or duration ago (24h)")
+	flag.BoolVar(&p.backfill, "backfill", false, "Backfill mode. Analyze files ignoring fileold, use since and until to set the time range")
+	flag.BoolVar(&p.dedup, "dedup", false, "Add a hash of the line and its offset as nanoseconds to request timestamp, replayed lines overwrite the same influx points")
+	flag.StringVar(&p.logFormat, "logformat", logFormatAuto, "Nginx log_format to parse. "+logFormatAuto+" | "+logFormatV2+" | "+logFormatV1+" | custom log_format string, $variables allowed")
+	flag.StringVar(&p.spoolDir, "spooldir", "", "Spool dir to persist points while influx is unreachable, replayed once reconnected. Disabled if empty")
+	flag.IntVar(&p.spoolMax, "spoolmax", 1024, "Spool max size in MB, oldest batches are dropped when exceeded")
+	flag.StringVar(&p.stateFile, "statefile", "", "State file to persist file offsets sent to influx, resuming from them on start. Disabled if empty")
+	flag.StringVar(&p.rulesFile, "rules", "", "Json rules file to drop or tag requests, matching agent, path, uid regex or ip CIDRs. Reloaded on change in daemon mode")
+	flag.StringVar(&p.deadLetter, "deadletter", "", "Dead letter file to write rejected log lines as json with the reason, - for stderr. Disabled if empty")
+	flag.StringVar(&p.validation, "validation", validationLenient, "Log lines validation. "+validationStrict+" (reject invalid request lines) | "+validationLenient+" (keep them with invalid tag)")
+	flag.BoolVar(&p.tsFallback, "tsfallback", false, "Use ingest time for log lines with invalid timestamp, with invalid tag. Otherwise they are rejected")
+	flag.BoolVar(&p.internalStats, "internalstats", false, "Send pipeline counters as "+internalMeasurement+" measurement every refresh and at exit")
 	flag.StringVar(&p.geoipdb, "geoipdb", "GeoLite2-City.mmdb", "Geoip db file")
 	flag.BoolVar(&p.daemon, "daemon", false, "Run in daemon mode. Tail files and send metrics continuously by limit or by refresh")
 	flag.BoolVar(&p.poll, "poll", false, "Use poll instead of inotify. daemon mode")
@@ -54,18 +137,52 @@ func (p *Params) init() {
 
 	flag.Parse()
 
+	if p.hllMerge {
+		return
+	}
+
 	p.checkParams()
 }
 
 func (p *Params) checkParams() {
+	if err := p.loadConfig(); err != nil {
+		flag.Usage()
+		log.Errorf("Check config params: %v", err)
+		os.Exit(1)
+	}
+
 	if !p.daemon && p.poll {
 		log.Warn("Setting -poll to false due to not daemon mode")
 		p.poll = false
 	}
+	if p.format == formatPrometheus && p.preview {
+		log.Warn("Setting -preview to false due to prometheus format")
+		p.preview = false
+	}
 	if p.format == formatJson && !p.preview {
 		log.Warn("Setting -preview to true due to json format")
 		p.preview = true
 	}
+	if p.preview && len(p.outputs) > 0 && p.outputs != sinkStdout {
+		log.Warn("Setting -outputs to " + sinkStdout + " due to preview")
+		p.outputs = sinkStdout
+	}
+	if len(p.outputs) == 0 {
+		switch {
+		case p.preview:
+			p.outputs = sinkStdout
+		case p.format == formatPrometheus:
+			p.outputs = sinkPrometheus
+		default:
+			p.outputs = sinkInflux
+		}
+	}
+
+	if p.limit <= 0 || p.refresh <= 0 || p.spoolMax <= 0 {
+		flag.Usage()
+		log.Error("Check your limit, refresh and/or spoolmax params, should be greater than 0.")
+		os.Exit(1)
+	}
 
 	if _, err := time.ParseDuration(p.filesOld); err != nil {
 		flag.Usage()
@@ -73,16 +190,144 @@ func (p *Params) checkParams() {
 		os.Exit(1)
 	}
 
-	if p.format != formatInflux && p.format != formatJson {
+	var err error
+	if p.sinceTime, err = parseTimeParam(p.since); err != nil {
+		flag.Usage()
+		log.Errorf("Check since params: %v", err)
+		os.Exit(1)
+	}
+	if p.untilTime, err = parseTimeParam(p.until); err != nil {
+		flag.Usage()
+		log.Errorf("Check until params: %v", err)
+		os.Exit(1)
+	}
+	if !p.sinceTime.IsZero() && !p.untilTime.IsZero() && !p.sinceTime.Before(p.untilTime) {
+		flag.Usage()
+		log.Error("Check your since and until params, since should be before until.")
+		os.Exit(1)
+	}
+	if p.backfill && p.daemon {
+		flag.Usage()
+		log.Error("Check your backfill and daemon params, backfill mode can't run in daemon mode.")
+		os.Exit(1)
+	}
+
+	if p.validation != validationStrict && p.validation != validationLenient {
+		flag.Usage()
+		log.Error("Check your validation params, " + validationStrict + " | " + validationLenient)
+		os.Exit(1)
+	}
+
+	if p.logFormats, err = newLogFormats(p.logFormat); err != nil {
+		flag.Usage()
+		log.Errorf("Check logformat params: %v", err)
+		os.Exit(1)
+	}
+
+	if p.schema, err = newSchema(p.measurement, p.tags, p.fields); err != nil {
+		flag.Usage()
+		log.Errorf("Check schema params: %v", err)
+		os.Exit(1)
+	}
+
+	if _, err = newRollup(p.rollups, p.rollupUnique, p.hllPrecision, p.instance); err != nil {
+		flag.Usage()
+		log.Errorf("Check rollups params: %v", err)
+		os.Exit(1)
+	}
+
+	saltRotate, err := time.ParseDuration(p.saltRotate)
+	if err != nil {
+		flag.Usage()
+		log.Errorf("Check saltrotate params: %v", err)
+		os.Exit(1)
+	}
+	if p.privacy, err = newPrivacy(p.anonymize, p.anonKey, p.hashUid, saltRotate); err != nil {
+		flag.Usage()
+		log.Errorf("Check privacy params: %v", err)
+		os.Exit(1)
+	}
+
+	if p.format != formatInflux && p.format != formatJson && p.format != formatPrometheus {
+		flag.Usage()
+		log.Error("Check your format params, " + formatInflux + " | " + formatJson + " | " + formatPrometheus)
+		os.Exit(1)
+	}
+	if p.sinks, err = newSinks(p.outputs, p.format); err != nil {
+		flag.Usage()
+		log.Errorf("Check outputs params: %v", err)
+		os.Exit(1)
+	}
+
+	if p.syslogListeners, err = newSyslogListeners(p.syslog); err != nil {
+		flag.Usage()
+		log.Errorf("Check syslog params: %v", err)
+		os.Exit(1)
+	}
+	if len(p.syslogListeners) > 0 && !p.daemon {
+		flag.Usage()
+		log.Error("Check your syslog and daemon params, syslog requires daemon mode.")
+		os.Exit(1)
+	}
+
+	if p.ingest && (!p.daemon || len(p.listen) == 0) {
+		flag.Usage()
+		log.Error("Check your daemon and/or listen params, required by ingest.")
+		os.Exit(1)
+	}
+
+	if hasSink(p.sinks, sinkPrometheus) && (!p.daemon || len(p.listen) == 0) {
 		flag.Usage()
-		log.Error("Check your format params, " + formatInflux + " | " + formatJson)
+		log.Error("Check your daemon and/or listen params, required by " + sinkPrometheus + " output.")
 		os.Exit(1)
 	}
-	if p.format == "influx" && !p.preview {
-		if len(p.influxdb) == 0 || len(p.influxurl) == 0 {
+	if u, err := url.Parse(p.influxurl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		flag.Usage()
+		log.Error("Check your influxurl params, should be http(s)://host:port.")
+		os.Exit(1)
+	}
+	if p.influxversion != influxV1 && p.influxversion != influxV2 {
+		flag.Usage()
+		log.Errorf("Check your influxversion params, %d | %d", influxV1, influxV2)
+		os.Exit(1)
+	}
+	if hasSink(p.sinks, sinkInflux) {
+		if p.influxversion == influxV2 {
+			if len(p.influxorg) == 0 || len(p.influxbucket) == 0 || len(p.influxtoken) == 0 || len(p.influxurl) == 0 {
+				flag.Usage()
+				log.Error("Check your influxorg, influxbucket, influxtoken and/or influxurl params.")
+				os.Exit(1)
+			}
+		} else if len(p.influxdb) == 0 || len(p.influxurl) == 0 {
 			flag.Usage()
 			log.Error("Check your influxdb and/or influxurl params.")
 			os.Exit(1)
 		}
 	}
 }
+
+// Influx points are written, or printed in preview
+func (p *Params) influxOutput() bool {
+	if p.preview {
+		return p.format == formatInflux
+	}
+	return hasSink(p.sinks, sinkInflux)
+}
+
+// Parse a time param, RFC3339, date or duration ago. Empty is zero time
+func parseTimeParam(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not RFC3339, date (2006-01-02) or duration", s)
+	}
+	return time.Now().Add(-d), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/oschwald/maxminddb-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Parser holds the compiled log formats and the geoip reader, shared by all files for the whole process
+type Parser struct {
+	formats    []*LogFormat
+	geoip      *maxminddb.Reader
+	privacy    *Privacy
+	rules      *Rules
+	strict     bool // Reject records with invalid request, otherwise flag them
+	tsFallback bool // Use ingest time if timestamp is invalid, otherwise reject
+}
+
+func newParser(formats []*LogFormat, geoipdb string, privacy *Privacy) (*Parser, error) {
+	var p = &Parser{
+		formats: formats,
+		privacy: privacy,
+	}
+
+	if len(geoipdb) > 0 {
+		db, err := maxminddb.Open(geoipdb)
+		if err != nil {
+			return nil, fmt.Errorf("opening geoip db %s: %v", geoipdb, err)
+		}
+		p.geoip = db
+	}
+
+	return p, nil
+}
+
+func (p *Parser) Close() {
+	if p.geoip == nil {
+		return
+	}
+	message := "Closing geoip db..."
+	err := p.geoip.Close()
+	check(err, message)
+	log.Debug(message)
+}
+
+// Parse a new request from the input string
+func (p *Parser) parse(str string) (*Request, error) {
+	req := &Request{}
+	if err := req.getData(str, p); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
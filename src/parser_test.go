@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const benchLine = `[21/Mar/2016:02:33:29 +0000] git.rancher.io 10.0.0.1 81.2.69.142, 10.0.0.2 "GET /rancher-catalog.git/info/refs?service=git-upload-pack HTTP/1.1" 200 1234 "-" "git/2.17.1" 0.010 0.010 "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f"`
+
+// Geoip db used by benchmarks, GEOIPDB env or the default -geoipdb value
+func benchGeoipdb(b *testing.B) string {
+	f := os.Getenv("GEOIPDB")
+	if len(f) == 0 {
+		f = "GeoLite2-City.mmdb"
+	}
+	if _, err := os.Stat(f); err != nil {
+		b.Skipf("Geoip db %s not found", f)
+	}
+	return f
+}
+
+func benchParser(b *testing.B, geoipdb string) *Parser {
+	formats, err := newLogFormats(logFormatAuto)
+	if err != nil {
+		b.Fatal(err)
+	}
+	p, err := newParser(formats, geoipdb, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+// Parse with compiled formats, no geoip
+func BenchmarkParse(b *testing.B) {
+	p := benchParser(b, "")
+	defer p.Close()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := p.parse(benchLine); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Compile both formats on every line, as getData used to do
+func BenchmarkParseCompileEachLine(b *testing.B) {
+	exprs := []string{}
+	for _, name := range logFormatAutoOrder {
+		l, err := newLogFormat(name, logFormatPresets[name])
+		if err != nil {
+			b.Fatal(err)
+		}
+		exprs = append(exprs, l.regex.String())
+	}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, expr := range exprs {
+			logline, err := regexp.Compile(expr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			logline.FindStringSubmatch(benchLine)
+		}
+	}
+}
+
+// Parse with compiled formats and the shared geoip reader
+func BenchmarkParseGeoip(b *testing.B) {
+	p := benchParser(b, benchGeoipdb(b))
+	defer p.Close()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := p.parse(benchLine); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Parse with compiled formats, opening the geoip db on every line, as getLocation used to do
+func BenchmarkParseGeoipOpenEachLine(b *testing.B) {
+	geoipdb := benchGeoipdb(b)
+	p := benchParser(b, "")
+	defer p.Close()
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		req, err := p.parse(benchLine)
+		if err != nil {
+			b.Fatal(err)
+		}
+		db, err := maxminddb.Open(geoipdb)
+		if err != nil {
+			b.Fatal(err)
+		}
+		req.getLocation(db)
+		db.Close()
+	}
+}
+
+// Parse from concurrent goroutines sharing the same parser, as file readers do
+func BenchmarkParseGeoipParallel(b *testing.B) {
+	p := benchParser(b, benchGeoipdb(b))
+	defer p.Close()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := p.parse(benchLine); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stdinPath    = "-"
+	stdinChannel = "stdin"
+)
+
+// Get data from stdin until EOF or stop
+func (r *Requests) getDataByStdin() {
+	stop, data, ok := r.Control.Get(stdinChannel)
+	if !ok {
+		log.Error("Getting reader channels ", stdinChannel)
+		return
+	}
+
+	log.Info("Analyzing ", stdinChannel)
+	defer log.Info("Closed ", stdinChannel)
+
+	r.getDataByReader(os.Stdin, reqSource{File: stdinChannel, Stream: true}, stop, data)
+}
+
+// Get data from a named pipe. In daemon mode it's opened read write, so it isn't closed by writers
+func (r *Requests) getDataByPipe(f string) {
+	stop, data, ok := r.Control.Get(f)
+	if !ok {
+		log.Error("Getting reader channels ", f)
+		return
+	}
+
+	flags := os.O_RDONLY
+	if r.Config.daemon {
+		flags = os.O_RDWR
+	}
+	in, err := os.OpenFile(f, flags, 0)
+	if err != nil {
+		log.Error("[Error]: ", err)
+		return
+	}
+	defer in.Close()
+
+	log.Info("Analyzing pipe ", f)
+	defer log.Info("Closed file ", f)
+
+	r.getDataByReader(in, reqSource{File: f, Stream: true}, stop, data)
+}
+
+// Start stdin reader and writer, registered in the channel list as a file
+func (r *Requests) getStdinReaders(in, out *sync.WaitGroup) {
+	_, data, err := r.Control.Add(stdinChannel)
+	if err != nil {
+		log.Error("Creating control channels ", stdinChannel)
+		return
+	}
+	st := r.Status.file(stdinChannel)
+
+	in.Add(1)
+	go func() {
+		defer in.Done()
+		defer r.Control.Delete(stdinChannel)
+		r.getDataByStdin()
+	}()
+
+	out.Add(1)
+	go func() {
+		defer out.Done()
+		defer r.Status.remove(stdinChannel)
+		r.getOutput(data, st)
+	}()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	anonymizeNone     = "none"
+	anonymizeTruncate = "truncate"
+	anonymizeHmac     = "hmac"
+)
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+
+	// Ip and uuid candidates in a raw log line, ips are checked by net.ParseIP
+	ipv4Token = regexp.MustCompile(`(?:[0-9]{1,3}\.){3}[0-9]{1,3}`)
+	ipv6Token = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f]*:[0-9A-Fa-f:.]*`)
+	uuidToken = regexp.MustCompile(`[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}`)
+)
+
+// Privacy anonymizes request ips and hashes uids, after geoip lookup
+type Privacy struct {
+	anonymize  string
+	key        []byte
+	hashUid    bool
+	saltRotate time.Duration
+}
+
+func newPrivacy(anonymize, key string, hashUid bool, saltRotate time.Duration) (*Privacy, error) {
+	var p = &Privacy{
+		anonymize:  anonymize,
+		key:        []byte(key),
+		hashUid:    hashUid,
+		saltRotate: saltRotate,
+	}
+
+	switch anonymize {
+	case anonymizeNone, anonymizeTruncate, anonymizeHmac:
+	default:
+		return nil, fmt.Errorf("anonymize should be %s | %s | %s", anonymizeNone, anonymizeTruncate, anonymizeHmac)
+	}
+	if (anonymize == anonymizeHmac || hashUid) && len(p.key) == 0 {
+		return nil, fmt.Errorf("anonkey is required by hmac anonymize and uid hashing")
+	}
+	if saltRotate < 0 {
+		return nil, fmt.Errorf("saltrotate should be positive")
+	}
+
+	return p, nil
+}
+
+func (p *Privacy) enabled() bool {
+	return p.anonymize != anonymizeNone || p.hashUid
+}
+
+func (p *Privacy) apply(r *Request) {
+	switch p.anonymize {
+	case anonymizeTruncate:
+		r.Ip = truncateIp(r.Ip)
+	case anonymizeHmac:
+		r.Ip = hmacHex(p.key, r.Ip, 16)
+	}
+
+	if p.hashUid && r.Uid != "-" && len(r.Uid) > 0 {
+		r.Uid = hmacHex(p.salt(r.Timestamp), r.Uid, 32)
+	}
+}
+
+// Redact a raw line that could not be parsed, anonymizing any ip and hashing any uuid in it.
+// The line timestamp is unknown, so uuids are hashed by anonkey without the rotated salt
+func (p *Privacy) redact(line string) string {
+	if p.anonymize != anonymizeNone {
+		ip := func(s string) string {
+			if net.ParseIP(s) == nil {
+				return s
+			}
+			if p.anonymize == anonymizeTruncate {
+				return truncateIp(s)
+			}
+			return hmacHex(p.key, s, 16)
+		}
+		line = ipv4Token.ReplaceAllStringFunc(line, ip)
+		line = ipv6Token.ReplaceAllStringFunc(line, ip)
+	}
+	if p.hashUid {
+		line = uuidToken.ReplaceAllStringFunc(line, func(s string) string {
+			return hmacHex(p.key, s, 32)
+		})
+	}
+	return line
+}
+
+// Salt for the rotation period of the timestamp, the same uid gets a different hash every period
+func (p *Privacy) salt(ts time.Time) []byte {
+	var period int64
+	if p.saltRotate > 0 {
+		period = ts.Truncate(p.saltRotate).Unix()
+	}
+	return []byte(hmacHex(p.key, strconv.FormatInt(period, 10), 64))
+}
+
+// Truncate ip to /24 for IPv4 and /48 for IPv6
+func truncateIp(s string) string {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return s
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(ipv4Mask).String()
+	}
+	return ip.Mask(ipv6Mask).String()
+}
+
+func hmacHex(key []byte, s string, length int) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))[:length]
+}
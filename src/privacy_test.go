@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTruncateIp(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"81.2.69.142", "81.2.69.0"},
+		{" 81.2.69.142", "81.2.69.0"},
+		{"10.0.0.255", "10.0.0.0"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1::"},
+		{"::ffff:81.2.69.142", "81.2.69.0"},
+		{"::1", "::"},
+		{"-", "-"},
+		{"", ""},
+		{"not an ip", "not an ip"},
+	}
+
+	for _, test := range tests {
+		if ip := truncateIp(test.ip); ip != test.want {
+			t.Errorf("truncateIp(%q) = %q, want %q", test.ip, ip, test.want)
+		}
+	}
+}
+
+func TestHmacHex(t *testing.T) {
+	tests := []struct {
+		key    string
+		s      string
+		length int
+		want   string
+	}{
+		{"key", "The quick brown fox jumps over the lazy dog", 64, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		{"key", "The quick brown fox jumps over the lazy dog", 16, "f7bc83f430538424"},
+		{"", "", 32, "b613679a0814d9ec772f95d778c35fc5"},
+	}
+
+	for _, test := range tests {
+		if h := hmacHex([]byte(test.key), test.s, test.length); h != test.want {
+			t.Errorf("hmacHex(%q, %q, %d) = %q, want %q", test.key, test.s, test.length, h, test.want)
+		}
+	}
+}
+
+func TestPrivacyApply(t *testing.T) {
+	p, err := newPrivacy(anonymizeHmac, "key", true, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2019, time.August, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ts   time.Time
+		uid  string
+		same bool // Same uid hash as the first test
+	}{
+		{day, "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f", true},
+		{day.Add(13 * time.Hour), "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f", true},
+		{day.Add(14 * time.Hour), "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f", false},
+		{day, "00000000-3a1c-4f5a-9b7e-5f2f0c1d1e2f", false},
+	}
+
+	var first string
+	for index, test := range tests {
+		r := &Request{Ip: "81.2.69.142", Uid: test.uid, Timestamp: test.ts}
+		p.apply(r)
+		if r.Ip != hmacHex([]byte("key"), "81.2.69.142", 16) {
+			t.Errorf("apply ip %q, want keyed hmac", r.Ip)
+		}
+		if len(r.Uid) != 32 || r.Uid == test.uid {
+			t.Errorf("apply uid %q, want 32 chars hash", r.Uid)
+		}
+		if index == 0 {
+			first = r.Uid
+			continue
+		}
+		if (r.Uid == first) != test.same {
+			t.Errorf("apply uid %s at %v, same hash as %s should be %v", test.uid, test.ts, first, test.same)
+		}
+	}
+
+	r := &Request{Ip: "81.2.69.142", Uid: "-"}
+	p.apply(r)
+	if r.Uid != "-" {
+		t.Errorf("apply empty uid %q, want -", r.Uid)
+	}
+}
+
+func TestNewPrivacy(t *testing.T) {
+	tests := []struct {
+		anonymize string
+		key       string
+		hashUid   bool
+		err       bool
+	}{
+		{anonymizeNone, "", false, false},
+		{anonymizeTruncate, "", false, false},
+		{anonymizeHmac, "key", false, false},
+		{anonymizeHmac, "", false, true},
+		{anonymizeNone, "", true, true},
+		{"mask", "key", false, true},
+	}
+
+	for _, test := range tests {
+		_, err := newPrivacy(test.anonymize, test.key, test.hashUid, 0)
+		if (err != nil) != test.err {
+			t.Errorf("newPrivacy(%q, %q, %v) error %v, want error %v", test.anonymize, test.key, test.hashUid, err, test.err)
+		}
+	}
+}
+
+func TestPrivacyRedact(t *testing.T) {
+	const uid = "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f"
+	line := `[21/Mar/2016:02:33:29 +0000] git.rancher.io 81.2.69.142:4433 [2001:db8:1:2::1]:443 "GET / HTTP/1.1" 12:30:01 "` + uid + `"`
+	tests := []struct {
+		anonymize string
+		hashUid   bool
+		want      string
+	}{
+		{anonymizeNone, false, line},
+		{anonymizeTruncate, false, `[21/Mar/2016:02:33:29 +0000] git.rancher.io 81.2.69.0:4433 [2001:db8:1::]:443 "GET / HTTP/1.1" 12:30:01 "` + uid + `"`},
+		{anonymizeHmac, true, `[21/Mar/2016:02:33:29 +0000] git.rancher.io ` + hmacHex([]byte("key"), "81.2.69.142", 16) + `:4433 [` + hmacHex([]byte("key"), "2001:db8:1:2::1", 16) + `]:443 "GET / HTTP/1.1" 12:30:01 "` + hmacHex([]byte("key"), uid, 32) + `"`},
+	}
+
+	for _, test := range tests {
+		p, err := newPrivacy(test.anonymize, "key", test.hashUid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redacted := p.redact(line); redacted != test.want {
+			t.Errorf("%s: redact(%q) = %q, want %q", test.anonymize, line, redacted, test.want)
+		}
+	}
+}
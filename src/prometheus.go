@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type promRequestLabels struct {
+	Host    string
+	Path    string
+	Status  string
+	Country string
+	Format  string
+}
+
+func (l promRequestLabels) String() string {
+	return fmt.Sprintf("host=%s,path=%s,status=%s,country=%s,log_format=%s",
+		promQuote(l.Host), promQuote(l.Path), promQuote(l.Status), promQuote(l.Country), promQuote(l.Format))
+}
+
+type promGauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+type promCounters struct {
+	name   string
+	help   string
+	label  string
+	values func() map[string]uint64
+}
+
+// Prometheus aggregated counters
+type Prometheus struct {
+	sync.Mutex
+	requests map[promRequestLabels]uint64
+	gauges   []promGauge
+	counters []promCounters
+}
+
+func newPrometheus() *Prometheus {
+	return &Prometheus{
+		requests: map[promRequestLabels]uint64{},
+	}
+}
+
+// Add a gauge, getting its value on every scrape
+func (p *Prometheus) addGauge(name, help string, value func() float64) {
+	p.Lock()
+	p.gauges = append(p.gauges, promGauge{name: name, help: help, value: value})
+	p.Unlock()
+}
+
+func (p *Prometheus) add(req *Request) {
+	l := promRequestLabels{
+		Host:    req.Host,
+		Path:    req.Catalog.Path,
+		Status:  req.Status,
+		Country: req.Location.Country.ISOCode,
+		Format:  req.Format,
+	}
+
+	p.Lock()
+	p.requests[l]++
+	p.Unlock()
+}
+
+// Add counters by a label, getting their values on every scrape. A single counter if label is empty
+func (p *Prometheus) addCounters(name, help, label string, values func() map[string]uint64) {
+	p.Lock()
+	p.counters = append(p.counters, promCounters{name: name, help: help, label: label, values: values})
+	p.Unlock()
+}
+
+// Write metrics in prometheus text format
+func (p *Prometheus) write(w io.Writer) {
+	p.Lock()
+	lines := make([]string, 0, len(p.requests))
+	for l, v := range p.requests {
+		lines = append(lines, fmt.Sprintf("catalog_requests_total{%s} %d\n", l, v))
+	}
+	gauges := p.gauges
+	counters := p.counters
+	p.Unlock()
+
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		values := c.values()
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if len(c.label) == 0 {
+				fmt.Fprintf(w, "%s %d\n", c.name, values[k])
+				continue
+			}
+			fmt.Fprintf(w, "%s{%s=%s} %d\n", c.name, c.label, promQuote(k), values[k])
+		}
+	}
+
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		fmt.Fprintf(w, "%s %g\n", g.name, g.value())
+	}
+
+	sort.Strings(lines)
+
+	fmt.Fprintln(w, "# HELP catalog_requests_total Catalog requests by host, path, status, country and log format version.")
+	fmt.Fprintln(w, "# TYPE catalog_requests_total counter")
+	for _, line := range lines {
+		io.WriteString(w, line)
+	}
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.write(w)
+}
+
+// Quote and escape prometheus label value
+func promQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+func (r *Requests) sendToPrometheus(data chan *Request) {
+	for {
+		select {
+		case req, ok := <-data:
+			if !ok {
+				return
+			}
+			r.Metrics.add(req)
+		}
+	}
+}
+
+// Start the http server, daemon mode
+func (r *Requests) listen() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Metrics)
+	mux.HandleFunc("/status", r.serveStatus)
+	mux.HandleFunc("/healthz", r.serveHealthz)
+	mux.HandleFunc("/readyz", r.serveReadyz)
+	if r.Config.ingest {
+		mux.HandleFunc("/ingest", r.serveIngest)
+	}
+
+	log.Info("Listening http on ", r.Config.listen)
+	go func() {
+		err := http.ListenAndServe(r.Config.listen, mux)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	stdlog "log"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -37,7 +39,13 @@ type Request struct {
 	Status    string      `json:"status"`    // Responses status code (200, 400, etc)
 	Referer   string      `json:"referer"`   // Referer (usually is set to "-")
 	Agent     string      `json:"agent"`     // User agent string
+	Client    reqClient   `json:"client"`    // User agent client
+	Catalog   reqCatalog  `json:"catalog"`   // Path catalog operation
+	Filter    string      `json:"filter"`    // Filter rule tag
+	Invalid   string      `json:"invalid"`   // Validation error reason, lenient mode
 	Uid       string      `json:"uid"`       // User agent string
+	Format    string      `json:"-"`         // Log format matched
+	Source    reqSource   `json:"-"`         // Log file and offset
 	Location  reqLocation `json:"location"`  // Remote IP location
 	Timestamp time.Time   `json:"timestamp"` // Request timestamp (UTC)
 }
@@ -67,25 +75,8 @@ func (req *Request) parseTimestamp(str string) error {
 	return err
 }
 
-func (r *Request) getPoint() *influx.Point {
-	var n = "requests"
-	v := map[string]interface{}{
-		"ip":  r.Ip,
-		"uid": r.Uid,
-	}
-	t := map[string]string{
-		"host":            r.Host,
-		"ip":              r.Ip,
-		"uid":             r.Uid,
-		"method":          r.Method,
-		"path":            r.Path,
-		"status":          r.Status,
-		"city":            r.Location.City,
-		"country":         r.Location.Country.Name,
-		"country_isocode": r.Location.Country.ISOCode,
-	}
-
-	m, err := influx.NewPoint(n, t, v, r.Timestamp)
+func (r *Request) getPoint(s *Schema) *influx.Point {
+	m, err := influx.NewPoint(s.Measurement, s.tags(r), s.fields(r), r.Timestamp)
 	if err != nil {
 		log.Warn(err)
 	}
@@ -107,17 +98,15 @@ func (r *Request) printJson() {
 
 }
 
-func (r *Request) printInflux() {
-	p := r.getPoint()
+func (r *Request) printInflux(s *Schema) {
+	p := r.getPoint(s)
 	fmt.Println(p.String())
 }
 
-func (r *Request) getLocation(geoipdb string) {
-	db, err := maxminddb.Open(geoipdb)
-	if err != nil {
-		log.Fatal(err)
+func (r *Request) getLocation(db *maxminddb.Reader) {
+	if db == nil {
+		return
 	}
-	defer db.Close()
 
 	ip := net.ParseIP(r.Ip)
 
@@ -131,10 +120,9 @@ func (r *Request) getLocation(geoipdb string) {
 		} `maxminddb:"country"`
 	} // Or any appropriate struct
 
-	err = db.Lookup(ip, &record)
+	err := db.Lookup(ip, &record)
 	if err != nil {
-		log.Warnf("[WARN] error looking up geolocation for ip %s: %v", ip, err)
-		r.printJson()
+		log.Debugf("Error looking up geolocation: %v", err)
 		return
 	}
 
@@ -143,54 +131,83 @@ func (r *Request) getLocation(geoipdb string) {
 	r.Location.Country.ISOCode = record.Country.ISOCode
 }
 
-// Get data from the input string
-func (r *Request) getData(str string, geoipdb string) error {
-	var cli_ip string
-
-	// Log format V2 with Cloudfare info
-	//        log_format main '[$time_local] $http_host $remote_addr $http_x_forwarded_for, $proxy_address '
-	//                        '"$request" $status $body_bytes_sent "$http_referer" '
-	//                        '"$http_user_agent" $request_time $upstream_response_time "$http_x_install_uuid"';
-	logFormatV2 := "^\\[([^\\]]+)\\] ([^ ]+) ([^ ]+) ([^ ]+), ([^ ]+) \"([^\"]*)\" ([^ ]+) ([^ ]+) \"([^\"]*)\" \"([^\"]*)\" ([^ ]+) ([^ ]+) \"([^\"]*)\""
-
-	// Log format V1 direct connection
-	//        log_format main '[$time_local] $http_host $remote_addr $http_x_forwarded_for '
-	//                        '"$request" $status $body_bytes_sent "$http_referer" '
-	//                        '"$http_user_agent" $request_time $upstream_response_time "$http_x_install_uuid"';
-	logFormatV1 := "^\\[([^\\]]+)\\] ([^ ]+) ([^ ]+) ([^ ]+) \"([^\"]*)\" ([^ ]+) ([^ ]+) \"([^\"]*)\" \"([^\"]*)\" ([^ ]+) ([^ ]+) \"([^\"]*)\""
-
-	logFormatVersion := "2"
-
-	logline, err := regexp.Compile(logFormatV2)
-	if err != nil {
-		log.Fatal(err)
-		return err
-	}
+// Request dropped by filter rules
+var errDropped = errors.New("Dropped by rules.")
 
-	submatches := logline.FindStringSubmatch(str)
-
-	// If log format is not V2, trying with log format V1
-	if len(submatches) == 0 {
-		logline, err = regexp.Compile(logFormatV1)
-		if err != nil {
-			log.Fatal(err)
-			return err
+// Get data from the input string
+func (r *Request) getData(str string, p *Parser) error {
+	var format *LogFormat
+	var submatches []string
+	for _, format = range p.formats {
+		if submatches = format.match(str); submatches != nil {
+			break
 		}
-		submatches = logline.FindStringSubmatch(str)
-		if len(submatches) > 0 {
-			logFormatVersion = "1"
+	}
+	if submatches == nil {
+		return newParseError(reasonMismatch, nil)
+	}
+
+	var remoteAddr, forwardedFor, request string
+	var tsErr error
+	for index, field := range format.Fields {
+		value := submatches[index]
+		switch field {
+		case "time_local":
+			tsErr = r.parseTimestamp(value)
+		case "time_iso8601":
+			var ts time.Time
+			if ts, tsErr = time.Parse(time.RFC3339, value); tsErr == nil {
+				r.Timestamp = ts
+			}
+		case "http_host", "host":
+			r.Host = value
+		case "remote_addr":
+			remoteAddr = value
+		case "http_x_forwarded_for":
+			forwardedFor = value
+		case "request":
+			request = value
+		case "request_method":
+			r.Method = value
+		case "request_uri", "uri":
+			r.Path = value
+		case "server_protocol":
+			r.Proto = value
+		case "status":
+			r.Status = value
+		case "http_referer":
+			r.Referer = value
+		case "http_user_agent":
+			r.Agent = value
+		case "http_x_install_uuid":
+			r.Uid = value
 		}
 	}
 
-	if (len(submatches) != 14 && len(submatches) != 13) || submatches[2] == "-" || submatches[2] == "localhost" {
-		//log.Warn(submatches)
-		return errors.New("Bad format.")
+	if r.Host == "-" || r.Host == "localhost" {
+		return newParseError(reasonLocalhost, fmt.Errorf("host %s", r.Host))
+	}
+	if tsErr != nil {
+		if !p.tsFallback {
+			return newParseError(reasonBadTimestamp, tsErr)
+		}
+		r.Timestamp = time.Now().UTC().Truncate(time.Second)
+		r.Invalid = reasonBadTimestamp
+	}
+	if format.hasField("request") {
+		if err := r.parseRequest(request); err != nil {
+			if p.strict {
+				return newParseError(reasonInvalidRequest, err)
+			}
+			if len(r.Invalid) == 0 {
+				r.Invalid = reasonInvalidRequest
+			}
+		}
 	}
 
-	if len(submatches[4]) < 7 {
-		cli_ip = submatches[3]
-	} else {
-		cli_ip = submatches[4]
+	cli_ip := forwardedFor
+	if len(cli_ip) < 7 {
+		cli_ip = remoteAddr
 	}
 
 	if strings.Contains(cli_ip, ",") {
@@ -199,24 +216,18 @@ func (r *Request) getData(str string, geoipdb string) error {
 	}
 
 	r.Ip = cli_ip
-	r.Host = submatches[2]
-	r.parseTimestamp(submatches[1])
-	r.getLocation(geoipdb)
+	r.Format = format.Name
+	r.Client = parseAgent(r.Agent)
+	r.getLocation(p.geoip)
+	r.Catalog = parseCatalog(r.Host, r.Path)
 
-	if logFormatVersion == "2" {
-		r.Status = submatches[7]
-		r.Referer = submatches[9]
-		r.Agent = submatches[10]
-		r.Uid = submatches[13]
-		r.parseRequest(submatches[6])
+	// Rules match raw ip and uid, before privacy
+	if p.rules != nil && !p.rules.apply(r) {
+		return errDropped
 	}
 
-	if logFormatVersion == "1" {
-		r.Status = submatches[6]
-		r.Referer = submatches[8]
-		r.Agent = submatches[9]
-		r.Uid = submatches[12]
-		r.parseRequest(submatches[5])
+	if p.privacy != nil && p.privacy.enabled() {
+		p.privacy.apply(r)
 	}
 
 	return nil
@@ -289,22 +300,33 @@ func (c *ChannelList) Len() int {
 }
 
 // Initialize a new request from the input string
-func NewRequest(str string, geoipdb string) (*Request, error) {
-	req := &Request{}
-
-	req.getData(str, geoipdb)
-	return req, nil
+func NewRequest(str string, p *Parser) (*Request, error) {
+	return p.parse(str)
 }
 
 type Requests struct {
-	Exit    chan os.Signal
-	Control *ChannelList
-	Config  Params
+	Exit       chan os.Signal
+	Control    *ChannelList
+	Parser     *Parser
+	Metrics    *Prometheus
+	Spool      *Spool
+	State      *State
+	Rollup     *Rollup
+	DeadLetter *DeadLetter
+	Status     *Status
+	Internal   *Internal
+	Ingest     *Ingest
+	Lines      *lineCounter
+	Config     Params
 }
 
 func newRequests(conf Params) *Requests {
 	var r = &Requests{
 		Control: NewChannelList(),
+		Metrics: newPrometheus(),
+		Status:  newStatus(),
+		Ingest:  &Ingest{},
+		Lines:   newLineCounter(),
 		Config:  conf,
 	}
 
@@ -320,21 +342,166 @@ func newRequests(conf Params) *Requests {
 		log.SetLevel(log.DebugLevel)
 	}
 
+	var err error
+	r.Parser, err = newParser(conf.logFormats, conf.geoipdb, conf.privacy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Parser.strict = conf.validation == validationStrict
+	r.Parser.tsFallback = conf.tsFallback
+
+	if len(conf.spoolDir) > 0 {
+		r.Spool, err = newSpool(conf.spoolDir, int64(conf.spoolMax)*1024*1024)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Metrics.addGauge("catalog_spool_batches", "Batches pending in spool.", func() float64 {
+			files, _ := r.Spool.Len()
+			return float64(files)
+		})
+		r.Metrics.addGauge("catalog_spool_bytes", "Bytes pending in spool.", func() float64 {
+			_, size := r.Spool.Len()
+			return float64(size)
+		})
+	}
+
+	if len(conf.rollups) > 0 && conf.influxOutput() {
+		r.Rollup, err = newRollup(conf.rollups, conf.rollupUnique, conf.hllPrecision, conf.instance)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	r.DeadLetter, err = newDeadLetter(conf.deadLetter, conf.privacy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Metrics.addCounters("catalog_rejected_lines_total", "Log lines rejected by the parser, by reason.", "reason", r.DeadLetter.Counts)
+
+	if len(conf.sinks) > 1 {
+		r.Metrics.addCounters("catalog_sink_dropped_total", "Requests dropped while the sink was full, by sink.", "sink", func() map[string]uint64 {
+			return sinksDropped(conf.sinks)
+		})
+	}
+
+	r.Internal = newInternal(conf.logFormats)
+	r.addInternalMetrics()
+
+	if len(conf.rulesFile) > 0 {
+		r.Parser.rules, err = newRules(conf.rulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(conf.stateFile) > 0 {
+		r.State, err = newState(conf.stateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	return r
 }
 
 func (r *Requests) Close() {
+	signal.Stop(r.Exit)
 	close(r.Exit)
+	r.Parser.Close()
+	if counts := r.DeadLetter.String(); len(counts) > 0 {
+		log.Warn("Rejected lines: ", counts)
+	}
+	r.DeadLetter.Close()
+
+	for _, s := range r.Config.sinks {
+		if s.file != nil {
+			s.file.Close()
+		}
+	}
+
+}
+
+// Send points to influx. Unsent points are spooled if spool is enabled, replaying previous spooled points first.
+// Points influx rejects aren't retried, they are counted and saved to the spool rejected dir if enabled.
+// Returns false if points couldn't be sent nor spooled, so they aren't committed
+func (r *Requests) send(i Writer, points []influx.Point) bool {
+	sendOne := func(m []influx.Point) error {
+		return r.write(i, m, 1)
+	}
+
+	if r.Spool == nil {
+		err := sendOne(points)
+		if err != nil {
+			log.Error("[Error]: ", err)
+			if !isRetryable(err) {
+				r.reject(points)
+				return true
+			}
+		}
+		return err == nil
+	}
+
+	var err error
+	if r.Spool.replay(sendOne) {
+		if err = sendOne(points); err == nil {
+			return true
+		}
+		log.Error("[Error]: ", err)
+		if !isRetryable(err) {
+			r.reject(points)
+			return true
+		}
+	}
+
+	if err = r.Spool.push(points); err != nil {
+		log.Error("[Error]: ", err)
+		return false
+	}
+	return true
+}
 
+// Count points influx rejected, saving them to the spool rejected dir if enabled
+func (r *Requests) reject(points []influx.Point) {
+	log.Errorf("Influx rejected %d points, not retrying", len(points))
+	r.Internal.addRejected(len(points))
+	if r.Spool != nil {
+		if err := r.Spool.reject(points); err != nil {
+			log.Error("[Error]: ", err)
+		}
+	}
+}
+
+// Write points to influx, recording the result on status and internal stats
+func (r *Requests) write(i Writer, m []influx.Point, retry int) error {
+	err := i.sendToInflux(m, retry)
+	r.Status.write(len(m), err)
+	r.Internal.addWrite(len(m), i.Latency(), err)
+	return err
 }
 
-func (r *Requests) sendToInflux(data chan *Request) {
+// Commit the offset of the last point sent
+func (r *Requests) commit(src reqSource, st *fileStatus) {
+	st.setCommitted(src.Offset)
+	// Streams can't be resumed
+	if r.State == nil || src.Stream {
+		return
+	}
+	check(r.State.commit(src), "Saving file state ")
+}
+
+func (r *Requests) sendToInflux(data chan *Request, st *fileStatus) {
 	var points []influx.Point
+	var last reqSource
 	var index, p_len int
 
-	i := newInflux(r.Config.influxurl, r.Config.influxdb, r.Config.influxuser, r.Config.influxpass)
+	i := newWriter(r.Config)
 
-	if i.Check(5) {
+	ok := i.Check(5)
+	if !ok {
+		r.Status.write(0, errInfluxDisconnected)
+	}
+
+	if ok || r.Spool != nil {
 		stop := make(chan struct{}, 1)
 		connected := i.CheckConnect(r.Config.refresh, stop)
 		defer close(stop)
@@ -344,36 +511,54 @@ func (r *Requests) sendToInflux(data chan *Request) {
 
 		index = 0
 		for {
+			st.setPending(len(points))
 			select {
 			case <-connected:
-				return
+				r.Status.write(0, errInfluxDisconnected)
+				if r.Spool == nil {
+					return
+				}
+				log.Warn("Influx disconnected, spooling points until reconnected...")
+				connected = nil
 			case <-ticker.C:
 				log.Info("Sync: Sending ", len(points), " points")
 				if len(points) > 0 {
-					if !i.sendToInflux(points, 1) {
+					if !r.send(i, points) {
 						return
 					}
+					r.commit(last, st)
 					points = []influx.Point{}
+				} else if r.Spool != nil {
+					r.Spool.replay(func(m []influx.Point) error {
+						return r.write(i, m, 0)
+					})
+				}
+				if r.Spool != nil {
+					files, size := r.Spool.Len()
+					log.Info("Spool backlog: ", files, " batches, ", size, " bytes")
 				}
 			case req, ok := <-data:
 				if !ok {
 					p_len = len(points)
 					if p_len > 0 {
 						log.Info("Finalyzing batch: Sending ", p_len, " points")
-						if i.sendToInflux(points, 1) {
+						if r.send(i, points) {
+							r.commit(last, st)
 							points = []influx.Point{}
 						}
 					}
 					return
 				}
-				p := req.getPoint()
+				p := req.getPoint(r.Config.schema)
 				points = append(points, *p)
+				last = req.Source
 				p_len = len(points)
 				if p_len == r.Config.limit {
 					log.Info("Running batch: Sending ", p_len, " points")
-					if !i.sendToInflux(points, 1) {
+					if !r.send(i, points) {
 						return
 					}
+					r.commit(last, st)
 					points = []influx.Point{}
 				}
 				index++
@@ -388,16 +573,56 @@ func (r *Requests) getDataByFile(f string) {
 		log.Infof("Error accessing file %s, skipping...", f)
 		return
 	}
+	if fileInfo.Mode()&os.ModeNamedPipe != 0 {
+		r.getDataByPipe(f)
+		return
+	}
 	fileModTime := fileInfo.ModTime()
 	oldLimit, _ := time.ParseDuration(r.Config.filesOld)
-	if time.Since(fileModTime) > oldLimit {
+	if !r.Config.backfill && time.Since(fileModTime) > oldLimit {
 		log.Infof("File %s is older than %s, skipping...", f, oldLimit)
 		return
 	}
+	// Lines are written before file modification time
+	if !r.Config.sinceTime.IsZero() && fileModTime.Before(r.Config.sinceTime) {
+		log.Infof("File %s is older than %s, skipping...", f, r.Config.sinceTime.Format(time.RFC3339))
+		return
+	}
+
+	compressed := isCompressed(f)
+	if compressed && r.Config.daemon {
+		log.Infof("File %s is compressed, skipping in daemon mode...", f)
+		return
+	}
 
 	t_mode := tail.Config{Follow: r.Config.daemon, ReOpen: r.Config.daemon, Poll: r.Config.poll}
 
+	src := reqSource{File: f, Inode: fileInode(fileInfo)}
+	if r.State != nil {
+		if offset, ok := r.State.get(f, src.Inode); ok {
+			if compressed {
+				log.Infof("Resuming file %s from decompressed offset %d", f, offset)
+				src.Offset = offset
+			} else if offset <= fileInfo.Size() {
+				log.Infof("Resuming file %s from offset %d", f, offset)
+				src.Offset = offset
+				t_mode.Location = &tail.SeekInfo{Offset: offset, Whence: io.SeekStart}
+			} else {
+				log.Infof("File %s truncated, reading from start", f)
+			}
+		}
+	}
+
+	if compressed {
+		r.getDataByCompressedFile(f, src)
+		return
+	}
+
+	reopen := &reopenLog{}
+	t_mode.Logger = stdlog.New(reopen, "", 0)
+
 	log.Info("Analyzing ", f)
+	st := r.Status.file(f)
 	t, err := tail.TailFile(f, t_mode)
 	if err != nil {
 		log.Fatal(err)
@@ -413,6 +638,7 @@ func (r *Requests) getDataByFile(f string) {
 	defer t.Cleanup()
 
 	ticker := time.NewTicker(time.Second * time.Duration(60))
+	var reopened time.Time
 
 	for {
 		select {
@@ -420,6 +646,9 @@ func (r *Requests) getDataByFile(f string) {
 			fileInfo, err := os.Stat(f)
 			if os.IsNotExist(err) {
 				log.Infof("File %s not exist, closing...", f)
+				if r.State != nil {
+					check(r.State.delete(f), "Deleting file state ")
+				}
 				t.Kill(nil)
 				return
 			}
@@ -439,7 +668,18 @@ func (r *Requests) getDataByFile(f string) {
 				t.Stop()
 				return
 			}
-			r.getData(string(line.Text), data)
+			// File rotated or truncated and reopened, offsets are from the new file
+			if at := reopen.reopenedAt(); at.After(reopened) && line.Time.After(at) {
+				reopened = at
+				src.Inode, src.Offset = 0, 0
+				if fileInfo, err := os.Stat(f); err == nil {
+					src.Inode = fileInode(fileInfo)
+				}
+				log.Infof("File %s reopened, inode %d", f, src.Inode)
+			}
+			src.Offset += int64(len(line.Text)) + 1
+			st.setOffset(src.Offset)
+			r.getData(string(line.Text), src, data)
 		case <-stop:
 			t.Kill(nil)
 			return
@@ -459,11 +699,12 @@ func (r *Requests) getReadersByFiles(in, out *sync.WaitGroup) {
 			continue
 		}
 
-		_, _, err := r.Control.Add(f)
+		_, data, err := r.Control.Add(f)
 		if err != nil {
 			log.Error("Creating control channels ", f)
 			continue
 		}
+		st := r.Status.file(f)
 
 		in.Add(1)
 		go func(file string) {
@@ -474,11 +715,12 @@ func (r *Requests) getReadersByFiles(in, out *sync.WaitGroup) {
 		}(f)
 
 		out.Add(1)
-		go func(file string) {
+		go func(file string, data chan *Request, st *fileStatus) {
 			defer out.Done()
+			defer r.Status.remove(file)
 			defer log.Debug("Closed writer ", file)
-			r.getOutput(file)
-		}(f)
+			r.getOutput(data, st)
+		}(f, data, st)
 		newFiles++
 	}
 
@@ -491,7 +733,69 @@ func (r *Requests) getDataByFiles() {
 	outdone := make(chan struct{}, 1)
 	stopcheck := make(chan struct{}, 1)
 
-	r.getReadersByFiles(&in, &out)
+	if r.Config.daemon && len(r.Config.listen) > 0 {
+		r.listen()
+	}
+
+	if r.Config.filesPath == stdinPath {
+		r.getStdinReaders(&in, &out)
+	} else {
+		r.getReadersByFiles(&in, &out)
+	}
+
+	if len(r.Config.syslogListeners) > 0 {
+		r.getSyslogReaders(&in, &out)
+	}
+
+	if r.Config.ingest {
+		r.getIngestReaders(&in, &out)
+	}
+
+	if r.Config.daemon && r.Parser.rules != nil {
+		stoprules := make(chan struct{}, 1)
+		defer close(stoprules)
+		go r.Parser.rules.watch(10, stoprules)
+	}
+
+	if r.Rollup != nil {
+		stoprollup := make(chan struct{}, 1)
+		defer r.flushRollups()
+		defer close(stoprollup)
+		if r.Config.daemon {
+			go func() {
+				defer log.Debug("Closed rollups")
+				ticker := time.NewTicker(time.Second * time.Duration(r.Config.refresh))
+				for {
+					select {
+					case <-ticker.C:
+						r.flushRollups()
+					case <-stoprollup:
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	if r.Config.internalStats && r.Config.influxOutput() {
+		stopinternal := make(chan struct{}, 1)
+		defer r.flushInternal()
+		defer close(stopinternal)
+		if r.Config.daemon {
+			go func() {
+				defer log.Debug("Closed internal stats")
+				ticker := time.NewTicker(time.Second * time.Duration(r.Config.refresh))
+				for {
+					select {
+					case <-ticker.C:
+						r.flushInternal()
+					case <-stopinternal:
+						return
+					}
+				}
+			}()
+		}
+	}
 
 	go func() {
 		in.Wait()
@@ -513,6 +817,9 @@ func (r *Requests) getDataByFiles() {
 			for {
 				select {
 				case <-ticker.C:
+					if r.Config.filesPath == stdinPath {
+						continue
+					}
 					log.Info("Refreshing files")
 					r.getReadersByFiles(&in, &out)
 				case <-stopcheck:
@@ -545,47 +852,135 @@ func (r *Requests) getDataByFiles() {
 
 func (r *Requests) getDataByLines(lines []string, data chan *Request) {
 	for _, line := range lines {
-		r.getData(string(line), data)
+		r.getData(string(line), reqSource{}, data)
 	}
 }
 
-func (r *Requests) getData(line string, data chan *Request) {
-	req := &Request{}
-	err := req.getData(line, r.Config.geoipdb)
-	if err != nil {
-		//log.Debug("Error getting data, ", err)
-		return
+// Deterministic line identity, as nanoseconds to add to its second precision timestamp.
+// The line is hashed with its file offset, or its occurrence in the second for streams.
+// Same second requests get distinct timestamps, while replayed lines get the same
+func lineId(line string, n int64) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(line))
+	fmt.Fprintf(h, "\n%d", n)
+	return time.Duration(h.Sum64() % uint64(time.Second))
+}
+
+// Seconds of stream lines counted by source, lines arriving later than the window are counted again from 1
+const lineWindow = 60
+
+// Occurrences of byte identical lines in a second, by line hash
+type secondLines map[uint64]int64
+
+type sourceLines struct {
+	newest  int64
+	seconds map[int64]secondLines
+}
+
+// Count byte identical lines by stream source in a sliding window of recent seconds
+type lineCounter struct {
+	sync.Mutex
+	sources map[string]*sourceLines
+}
+
+func newLineCounter() *lineCounter {
+	return &lineCounter{sources: map[string]*sourceLines{}}
+}
+
+// Get the occurrence of the line in its second, evicting seconds out of the window
+func (c *lineCounter) next(src string, ts time.Time, line string) int64 {
+	c.Lock()
+	defer c.Unlock()
+
+	s := c.sources[src]
+	if s == nil {
+		s = &sourceLines{seconds: map[int64]secondLines{}}
+		c.sources[src] = s
 	}
 
-	data <- req
+	second := ts.Unix()
+	if second > s.newest {
+		s.newest = second
+		for old := range s.seconds {
+			if old <= second-lineWindow {
+				delete(s.seconds, old)
+			}
+		}
+	}
+
+	lines := s.seconds[second]
+	if lines == nil {
+		lines = secondLines{}
+		s.seconds[second] = lines
+	}
+	h := fnv.New64a()
+	h.Write([]byte(line))
+	key := h.Sum64()
+	lines[key]++
+	return lines[key]
 }
 
-func (r *Requests) getOutput(f string) {
-	_, data, ok := r.Control.Get(f)
-	if !ok {
-		log.Error("Getting writer channel ", f)
+// Check timestamp is between since and until params
+func (r *Requests) inTimeRange(ts time.Time) bool {
+	if !r.Config.sinceTime.IsZero() && ts.Before(r.Config.sinceTime) {
+		return false
+	}
+	if !r.Config.untilTime.IsZero() && !ts.Before(r.Config.untilTime) {
+		return false
+	}
+	return true
+}
+
+func (r *Requests) getData(line string, src reqSource, data chan *Request) {
+	r.Internal.addLine()
+	req, err := r.Parser.parse(line)
+	if err != nil {
+		if err == errDropped {
+			r.Internal.addDropped()
+		} else {
+			r.DeadLetter.add(src, line, err)
+		}
 		return
 	}
+	r.Internal.addParsed(req, r.Parser.geoip != nil)
+	req.Source = src
 
-	if r.Config.preview {
-		r.print(data)
-	} else {
-		r.sendToInflux(data)
+	if !r.inTimeRange(req.Timestamp) {
+		return
+	}
+
+	if r.Config.dedup {
+		n := src.Offset
+		if src.Stream {
+			n = r.Lines.next(src.File, req.Timestamp, line)
+		}
+		req.Timestamp = req.Timestamp.Add(lineId(line, n))
+	}
+
+	if r.Rollup != nil {
+		r.Rollup.add(req)
 	}
+
+	data <- req
+}
+
+// Writer channel is got on reader creation, as the reader may close and delete it before writer starts
+func (r *Requests) getOutput(data chan *Request, st *fileStatus) {
+	r.fanOut(data, st)
 }
 
-func (r *Requests) print(data chan *Request) {
+func (r *Requests) print(data chan *Request, format string) {
 	for {
 		select {
 		case req, ok := <-data:
 			if !ok {
 				return
 			}
-			switch r.Config.format {
+			switch format {
 			case formatJson:
 				req.printJson()
 			case formatInflux:
-				req.printInflux()
+				req.printInflux(r.Config.schema)
 			}
 		}
 	}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineId(t *testing.T) {
+	const line = `81.2.69.142 - - [21/Mar/2016:02:33:29 +0000] "GET / HTTP/1.1" 200 0`
+	tests := []struct {
+		line1 string
+		n1    int64
+		line2 string
+		n2    int64
+		same  bool
+	}{
+		{line, 100, line, 100, true},
+		{line, 1, line, 2, false},
+		{line, 100, line, 200, false},
+		{line, 1, line + " ", 1, false},
+		{line + "1", 2, line + "12", 0, false},
+	}
+
+	for _, test := range tests {
+		id1, id2 := lineId(test.line1, test.n1), lineId(test.line2, test.n2)
+		if id1 < 0 || id1 >= time.Second || id2 < 0 || id2 >= time.Second {
+			t.Errorf("lineId %v and %v, want less than a second", id1, id2)
+		}
+		if (id1 == id2) != test.same {
+			t.Errorf("lineId(%q, %d) = %v and lineId(%q, %d) = %v, same should be %v", test.line1, test.n1, id1, test.line2, test.n2, id2, test.same)
+		}
+	}
+}
+
+func TestLineCounter(t *testing.T) {
+	type line struct {
+		src    string
+		second int64
+		text   string
+		n      int64
+	}
+	tests := [][]line{
+		// Same second
+		{{"syslog/lb1", 0, "A", 1}, {"syslog/lb1", 0, "A", 2}, {"syslog/lb1", 0, "B", 1}, {"syslog/lb1", 0, "A", 3}},
+		// Interleaved seconds, out of order
+		{{"syslog/lb1", 0, "A", 1}, {"syslog/lb1", 1, "B", 1}, {"syslog/lb1", 0, "A", 2}, {"syslog/lb1", 1, "A", 1}, {"syslog/lb1", 0, "A", 3}},
+		// By source
+		{{"syslog/lb1", 0, "A", 1}, {"syslog/lb2", 0, "A", 1}, {"ingest/10.0.0.1", 0, "A", 1}, {"syslog/lb1", 0, "A", 2}},
+		// Within the window
+		{{"stdin", 0, "A", 1}, {"stdin", lineWindow - 1, "B", 1}, {"stdin", 0, "A", 2}},
+		// Evicted out of the window
+		{{"stdin", 0, "A", 1}, {"stdin", lineWindow, "B", 1}, {"stdin", 0, "A", 1}, {"stdin", 0, "A", 2}},
+	}
+
+	start := time.Date(2019, time.August, 1, 10, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		c := newLineCounter()
+		for index, l := range test {
+			ts := start.Add(time.Duration(l.second) * time.Second)
+			if n := c.next(l.src, ts, l.text); n != l.n {
+				t.Errorf("%v: line %d %s %q at %d = %d, want %d", test, index, l.src, l.text, l.second, n, l.n)
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Unique values counter
+type uniqueCounter interface {
+	add(s string)
+	count() uint64
+}
+
+const (
+	uniqueExact = "exact"
+	uniqueHLL   = "hll"
+)
+
+type exactSet map[string]struct{}
+
+func (e exactSet) add(s string) {
+	e[s] = struct{}{}
+}
+
+func (e exactSet) count() uint64 {
+	return uint64(len(e))
+}
+
+type rollupCounter struct {
+	total uint64
+	ips   uniqueCounter
+	uids  uniqueCounter
+}
+
+func newRollupCounter(unique string, p uint8) *rollupCounter {
+	if unique == uniqueHLL {
+		return &rollupCounter{
+			ips:  newHLL(p),
+			uids: newHLL(p),
+		}
+	}
+	return &rollupCounter{
+		ips:  exactSet{},
+		uids: exactSet{},
+	}
+}
+
+// Add unique counts fields, and serialized sketches if counted by hll
+func (c *rollupCounter) fields(v map[string]interface{}, ip string) map[string]interface{} {
+	v[ip] = int64(c.ips.count())
+	v["unique_uid"] = int64(c.uids.count())
+	if h, ok := c.ips.(*HLL); ok {
+		v["ip_sketch"] = h.String()
+	}
+	if h, ok := c.uids.(*HLL); ok {
+		v["uid_sketch"] = h.String()
+	}
+	return v
+}
+
+func (c *rollupCounter) add(req *Request) {
+	c.total++
+	c.ips.add(req.Ip)
+	if req.Uid != "-" && len(req.Uid) > 0 {
+		c.uids.add(req.Uid)
+	}
+}
+
+type rollupCatalog struct {
+	Catalog   string
+	Operation string
+}
+
+type rollupCountry struct {
+	Country    string
+	CountryISO string
+	rollupCatalog
+}
+
+type rollupBucket struct {
+	byPath    map[string]*rollupCounter
+	byCatalog map[rollupCatalog]*rollupCounter
+	byCountry map[rollupCountry]*rollupCounter
+	partial   time.Duration // Added to the bucket start, distinct points for every bucket created
+	dirty     bool
+	updated   time.Time
+}
+
+type rollupInterval struct {
+	name     string
+	duration time.Duration
+	buckets  map[time.Time]*rollupBucket
+}
+
+// Rollup aggregates requests by time buckets, replacing influx continuous queries.
+// Points are partial counts, by instance and by bucket created in process, to be summed when querying
+type Rollup struct {
+	sync.Mutex
+	intervals []*rollupInterval
+	unique    string
+	precision uint8
+	instance  string
+	partials  uint64
+}
+
+// Get a rollup for the comma separated intervals, counting uniques exactly or by hll. Example: 1h,24h
+func newRollup(s, unique string, precision int, instance string) (*Rollup, error) {
+	var r = &Rollup{
+		unique:    unique,
+		precision: uint8(precision),
+		instance:  instance,
+		partials:  uint64(time.Now().UnixNano()),
+	}
+	if len(r.instance) == 0 {
+		r.instance, _ = os.Hostname()
+	}
+
+	if unique != uniqueExact && unique != uniqueHLL {
+		return nil, fmt.Errorf("rollup unique should be %s | %s", uniqueExact, uniqueHLL)
+	}
+	if err := checkHLLPrecision(precision); err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		d, err := time.ParseDuration(name)
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("rollup interval %s should be at least 1m", name)
+		}
+		r.intervals = append(r.intervals, &rollupInterval{
+			name:     name,
+			duration: d,
+			buckets:  map[time.Time]*rollupBucket{},
+		})
+	}
+
+	return r, nil
+}
+
+func (r *Rollup) add(req *Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, i := range r.intervals {
+		start := req.Timestamp.Truncate(i.duration)
+		b, ok := i.buckets[start]
+		if !ok {
+			// Restarts and late requests for evicted buckets get a new partial, instead of overwriting the points
+			r.partials++
+			b = &rollupBucket{
+				byPath:    map[string]*rollupCounter{},
+				byCatalog: map[rollupCatalog]*rollupCounter{},
+				byCountry: map[rollupCountry]*rollupCounter{},
+				partial:   time.Duration(r.partials % uint64(time.Second)),
+			}
+			i.buckets[start] = b
+		}
+
+		path := req.Catalog.Path
+		c, ok := b.byPath[path]
+		if !ok {
+			c = newRollupCounter(r.unique, r.precision)
+			b.byPath[path] = c
+		}
+		c.add(req)
+
+		catalog := rollupCatalog{
+			Catalog:   req.Catalog.Catalog,
+			Operation: req.Catalog.Operation,
+		}
+		c, ok = b.byCatalog[catalog]
+		if !ok {
+			c = newRollupCounter(r.unique, r.precision)
+			b.byCatalog[catalog] = c
+		}
+		c.add(req)
+
+		country := rollupCountry{
+			Country:       req.Location.Country.Name,
+			CountryISO:    req.Location.Country.ISOCode,
+			rollupCatalog: catalog,
+		}
+		c, ok = b.byCountry[country]
+		if !ok {
+			c = newRollupCounter(r.unique, r.precision)
+			b.byCountry[country] = c
+		}
+		c.add(req)
+
+		b.dirty = true
+		b.updated = time.Now()
+	}
+}
+
+// Get points of buckets updated since last call. Buckets not updated for an interval are evicted
+func (r *Rollup) points() []influx.Point {
+	r.Lock()
+	defer r.Unlock()
+
+	var points []influx.Point
+	for _, i := range r.intervals {
+		for start, b := range i.buckets {
+			if b.dirty {
+				points = append(points, b.points(i.name, r.instance, start)...)
+				b.dirty = false
+			}
+			if time.Since(b.updated) > i.duration {
+				delete(i.buckets, start)
+			}
+		}
+	}
+
+	return points
+}
+
+func (b *rollupBucket) points(name, instance string, start time.Time) []influx.Point {
+	var points []influx.Point
+	ts := start.Add(b.partial)
+
+	for path, c := range b.byPath {
+		t := map[string]string{
+			"instance": instance,
+			"path":     path,
+		}
+		v := c.fields(map[string]interface{}{"total": int64(c.total)}, "unique_ip")
+		points = appendPoint(points, "byPath_"+name, t, v, ts)
+	}
+
+	for catalog, c := range b.byCatalog {
+		t := map[string]string{
+			"catalog":   catalog.Catalog,
+			"instance":  instance,
+			"operation": catalog.Operation,
+		}
+		v := c.fields(map[string]interface{}{"total": int64(c.total)}, "unique_ip")
+		points = appendPoint(points, "byCatalog_"+name, t, v, ts)
+	}
+
+	for country, c := range b.byCountry {
+		t := map[string]string{
+			"catalog":         country.Catalog,
+			"country":         country.Country,
+			"country_isocode": country.CountryISO,
+			"instance":        instance,
+			"operation":       country.Operation,
+		}
+		v := c.fields(map[string]interface{}{"total": int64(c.total)}, "unique")
+		points = appendPoint(points, "byCountry_"+name, t, v, ts)
+	}
+
+	return points
+}
+
+func appendPoint(points []influx.Point, n string, t map[string]string, v map[string]interface{}, ts time.Time) []influx.Point {
+	m, err := influx.NewPoint(n, t, v, ts)
+	if err != nil {
+		log.Warn(err)
+		return points
+	}
+	return append(points, *m)
+}
+
+// Send rollup points updated since last flush
+func (r *Requests) flushRollups() {
+	points := r.Rollup.points()
+	if len(points) == 0 {
+		return
+	}
+
+	if r.Config.preview {
+		for index := range points {
+			fmt.Println(points[index].String())
+		}
+		return
+	}
+
+	log.Info("Rollups: Sending ", len(points), " points")
+	i := newWriter(r.Config)
+	defer i.Close()
+	if !r.send(i, points) {
+		log.Error("Rollups: Failed to send ", len(points), " points")
+	}
+}
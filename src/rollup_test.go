@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+func newTestRollupRequest(ts time.Time, ip, uid, path, country string) *Request {
+	r := &Request{Ip: ip, Uid: uid, Host: "git.rancher.io", Path: path, Timestamp: ts}
+	r.Catalog = parseCatalog(r.Host, r.Path)
+	r.Location.Country.Name = country
+	return r
+}
+
+// Get a rollup point by measurement, sorted tags and bucket start
+func rollupPoint(points []influx.Point, key string, start time.Time) *influx.Point {
+	for index := range points {
+		pt := &points[index]
+		if strings.SplitN(pt.String(), " ", 2)[0] == key && pt.Time().Truncate(time.Second).Equal(start) {
+			return pt
+		}
+	}
+	return nil
+}
+
+func TestNewRollup(t *testing.T) {
+	tests := []struct {
+		intervals string
+		unique    string
+		precision int
+		err       bool
+	}{
+		{"1h,24h", uniqueExact, 14, false},
+		{" 1h , ", uniqueHLL, 12, false},
+		{"30s", uniqueExact, 14, true},
+		{"1x", uniqueExact, 14, true},
+		{"1h", "approx", 14, true},
+		{"1h", uniqueHLL, 2, true},
+	}
+
+	for _, test := range tests {
+		_, err := newRollup(test.intervals, test.unique, test.precision, "test")
+		if (err != nil) != test.err {
+			t.Errorf("newRollup(%q, %q, %d) error %v, want error %v", test.intervals, test.unique, test.precision, err, test.err)
+		}
+	}
+}
+
+func TestRollupPoints(t *testing.T) {
+	r, err := newRollup("1h,24h", uniqueExact, 14, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hour := time.Date(2019, time.August, 1, 10, 0, 0, 0, time.UTC)
+	const refs = "/rancher-catalog.git/info/refs?service=git-upload-pack"
+	const upload = "/rancher-catalog.git/git-upload-pack"
+	reqs := []*Request{
+		newTestRollupRequest(hour.Add(time.Minute), "81.2.69.142", "uid-1", refs, "Canada"),
+		newTestRollupRequest(hour.Add(2*time.Minute), "81.2.69.142", "uid-1", upload, "Canada"),
+		newTestRollupRequest(hour.Add(3*time.Minute), "81.2.69.143", "uid-2", refs, "Canada"),
+		newTestRollupRequest(hour.Add(4*time.Minute), "81.2.69.144", "-", "/rancher-catalog/info/refs", "France"),
+		newTestRollupRequest(hour.Add(61*time.Minute), "81.2.69.142", "uid-1", refs, "Canada"),
+	}
+	for _, req := range reqs {
+		r.add(req)
+	}
+
+	points := r.points()
+	tests := []struct {
+		key    string
+		hour   time.Time
+		fields map[string]interface{}
+	}{
+		{"byPath_1h,instance=test,path=/rancher-catalog.git/info/refs", hour, map[string]interface{}{"total": int64(2), "unique_ip": int64(2), "unique_uid": int64(2)}},
+		{"byPath_1h,instance=test,path=/rancher-catalog/info/refs", hour, map[string]interface{}{"total": int64(1), "unique_ip": int64(1), "unique_uid": int64(0)}},
+		{"byPath_1h,instance=test,path=/rancher-catalog.git/git-upload-pack", hour, map[string]interface{}{"total": int64(1), "unique_ip": int64(1), "unique_uid": int64(1)}},
+		{"byCatalog_1h,catalog=rancher-catalog,instance=test,operation=git-refs", hour, map[string]interface{}{"total": int64(3), "unique_ip": int64(3), "unique_uid": int64(2)}},
+		{"byCatalog_1h,catalog=rancher-catalog,instance=test,operation=git-upload-pack", hour, map[string]interface{}{"total": int64(1), "unique_ip": int64(1), "unique_uid": int64(1)}},
+		{"byCountry_1h,catalog=rancher-catalog,country=Canada,instance=test,operation=git-refs", hour, map[string]interface{}{"total": int64(2), "unique": int64(2), "unique_uid": int64(2)}},
+		{"byCountry_1h,catalog=rancher-catalog,country=France,instance=test,operation=git-refs", hour, map[string]interface{}{"total": int64(1), "unique": int64(1), "unique_uid": int64(0)}},
+		{"byCatalog_1h,catalog=rancher-catalog,instance=test,operation=git-refs", hour.Add(time.Hour), map[string]interface{}{"total": int64(1), "unique_ip": int64(1), "unique_uid": int64(1)}},
+		{"byCatalog_24h,catalog=rancher-catalog,instance=test,operation=git-refs", hour.Truncate(24 * time.Hour), map[string]interface{}{"total": int64(4), "unique_ip": int64(3), "unique_uid": int64(2)}},
+	}
+
+	// Points by bucket start, at most one partial by series and bucket
+	starts := map[time.Time]time.Time{}
+	for _, test := range tests {
+		pt := rollupPoint(points, test.key, test.hour)
+		if pt == nil {
+			t.Errorf("point %s at %v not found", test.key, test.hour)
+			continue
+		}
+		fields, _ := pt.Fields()
+		for name, value := range test.fields {
+			if fields[name] != value {
+				t.Errorf("point %s at %v field %s = %v, want %v", test.key, test.hour, name, fields[name], value)
+			}
+		}
+		if start, ok := starts[test.hour]; ok && !start.Equal(pt.Time()) {
+			t.Errorf("point %s at %v, want same partial %v as the bucket", test.key, pt.Time(), start)
+		}
+		starts[test.hour] = pt.Time()
+	}
+	if starts[hour].Equal(starts[hour.Add(time.Hour)].Add(-time.Hour)) {
+		t.Errorf("buckets %v and %v, want distinct partials", starts[hour], starts[hour.Add(time.Hour)])
+	}
+
+	if count := len(r.points()); count != 0 {
+		t.Errorf("got %d points without updates, want 0", count)
+	}
+}
+
+func TestRollupIntervals(t *testing.T) {
+	// Buckets of every interval at the same start are written
+	r, err := newRollup("1h,24h", uniqueExact, 14, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.add(newTestRollupRequest(time.Date(2019, time.August, 1, 0, 10, 0, 0, time.UTC), "81.2.69.142", "uid-1", "/index.yaml", "Canada"))
+	if count := len(r.points()); count != 6 {
+		t.Errorf("got %d points, want 6", count)
+	}
+}
+
+func TestRollupEviction(t *testing.T) {
+	r, err := newRollup("1h", uniqueHLL, 12, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hour := time.Date(2019, time.August, 1, 10, 0, 0, 0, time.UTC)
+	req := newTestRollupRequest(hour, "81.2.69.142", "uid-1", "/index.yaml", "Canada")
+	r.add(req)
+	first := r.points()
+	if len(first) != 3 {
+		t.Fatalf("got %d points, want 3", len(first))
+	}
+	fields, _ := first[0].Fields()
+	if _, ok := fields["ip_sketch"]; !ok {
+		t.Errorf("hll point fields %v, want ip_sketch", fields)
+	}
+	if _, ok := fields["uid_sketch"]; !ok {
+		t.Errorf("hll point fields %v, want uid_sketch", fields)
+	}
+
+	// Updated buckets are kept, and rewritten at the same partial
+	r.add(req)
+	if second := r.points(); len(second) != 3 || !second[0].Time().Equal(first[0].Time()) {
+		t.Errorf("updated bucket got %d points, want 3 at %v", len(second), first[0].Time())
+	}
+
+	// Buckets not updated for an interval are evicted, late requests get a new partial
+	for _, b := range r.intervals[0].buckets {
+		b.updated = time.Now().Add(-2 * time.Hour)
+	}
+	r.points()
+	if count := len(r.intervals[0].buckets); count != 0 {
+		t.Fatalf("got %d buckets, want evicted", count)
+	}
+	r.add(req)
+	late := r.points()
+	if len(late) != 3 {
+		t.Fatalf("late bucket got %d points, want 3", len(late))
+	}
+	if late[0].Time().Equal(first[0].Time()) || !late[0].Time().Truncate(time.Hour).Equal(hour) {
+		t.Errorf("late bucket at %v, want a new partial of %v", late[0].Time(), hour)
+	}
+	fields, _ = late[0].Fields()
+	if fields["total"] != int64(1) {
+		t.Errorf("late bucket total %v, want 1", fields["total"])
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ruleDrop = "drop"
+	ruleTag  = "tag"
+)
+
+// Rule matches requests by all its set conditions
+// Example: {"action": "tag", "tag": "ci", "agent": "(?i)jenkins", "ip": ["10.0.0.0/8"]}
+type Rule struct {
+	Action string   `json:"action"` // drop | tag
+	Tag    string   `json:"tag"`    // Filter tag value, tag action
+	Agent  string   `json:"agent"`  // User agent regex
+	Path   string   `json:"path"`   // Request path regex
+	Uid    string   `json:"uid"`    // Uid regex
+	Ip     []string `json:"ip"`     // Client ip CIDRs or ips
+	agent  *regexp.Regexp
+	path   *regexp.Regexp
+	uid    *regexp.Regexp
+	nets   []*net.IPNet
+}
+
+func (r *Rule) compile() error {
+	var err error
+	switch r.Action {
+	case ruleDrop:
+	case ruleTag:
+		if len(r.Tag) == 0 {
+			return fmt.Errorf("tag is required by tag action")
+		}
+	default:
+		return fmt.Errorf("action should be %s | %s", ruleDrop, ruleTag)
+	}
+
+	if len(r.Agent) > 0 {
+		if r.agent, err = regexp.Compile(r.Agent); err != nil {
+			return err
+		}
+	}
+	if len(r.Path) > 0 {
+		if r.path, err = regexp.Compile(r.Path); err != nil {
+			return err
+		}
+	}
+	if len(r.Uid) > 0 {
+		if r.uid, err = regexp.Compile(r.Uid); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range r.Ip {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		r.nets = append(r.nets, n)
+	}
+
+	if r.agent == nil && r.path == nil && r.uid == nil && len(r.nets) == 0 {
+		return fmt.Errorf("at least one of agent, path, uid or ip is required")
+	}
+	return nil
+}
+
+func (r *Rule) match(req *Request) bool {
+	if r.agent != nil && !r.agent.MatchString(req.Agent) {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(req.Path) {
+		return false
+	}
+	if r.uid != nil && !r.uid.MatchString(req.Uid) {
+		return false
+	}
+	if len(r.nets) > 0 {
+		ip := net.ParseIP(strings.TrimSpace(req.Ip))
+		if ip == nil {
+			return false
+		}
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Rules loaded from a json file, first matching rule wins. Hot reloaded in daemon mode
+type Rules struct {
+	sync.RWMutex
+	file    string
+	rules   []*Rule
+	modTime time.Time
+}
+
+func newRules(f string) (*Rules, error) {
+	var r = &Rules{
+		file: f,
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Rules) load() error {
+	fileInfo, err := os.Stat(r.file)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("parsing rules file %s: %v", r.file, err)
+	}
+	for index, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("rules file %s, rule %d: %v", r.file, index+1, err)
+		}
+	}
+
+	r.Lock()
+	r.rules = rules
+	r.modTime = fileInfo.ModTime()
+	r.Unlock()
+
+	log.Infof("Loaded %d rules from %s", len(rules), r.file)
+	return nil
+}
+
+// Reload rules file if modified, keeping current rules on error
+func (r *Rules) reload() {
+	fileInfo, err := os.Stat(r.file)
+	if err != nil {
+		log.Errorf("Reloading rules file %s: %v", r.file, err)
+		return
+	}
+
+	r.RLock()
+	modified := !fileInfo.ModTime().Equal(r.modTime)
+	r.RUnlock()
+
+	if modified {
+		if err := r.load(); err != nil {
+			log.Errorf("Reloading rules file %s, keeping current rules: %v", r.file, err)
+		}
+	}
+}
+
+// Reload rules file every interval seconds, until stop
+func (r *Rules) watch(interval int, stop chan struct{}) {
+	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Apply first matching rule. Returns false if request is dropped
+func (r *Rules) apply(req *Request) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, rule := range r.rules {
+		if !rule.match(req) {
+			continue
+		}
+		if rule.Action == ruleDrop {
+			return false
+		}
+		req.Filter = rule.Tag
+		return true
+	}
+	return true
+}
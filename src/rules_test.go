@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRuleCompile(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		err  bool
+	}{
+		{Rule{Action: ruleDrop, Agent: "(?i)bot"}, false},
+		{Rule{Action: ruleTag, Tag: "ci", Ip: []string{"10.0.0.0/8", "81.2.69.142", "2001:db8::1"}}, false},
+		{Rule{Action: ruleTag, Agent: "(?i)jenkins"}, true},
+		{Rule{Action: "keep", Agent: "(?i)bot"}, true},
+		{Rule{Action: ruleDrop}, true},
+		{Rule{Action: ruleDrop, Path: "("}, true},
+		{Rule{Action: ruleDrop, Ip: []string{"10.0.0.0/33"}}, true},
+		{Rule{Action: ruleDrop, Ip: []string{"host"}}, true},
+	}
+
+	for _, test := range tests {
+		rule := test.rule
+		if err := rule.compile(); (err != nil) != test.err {
+			t.Errorf("compile(%+v) error %v, want error %v", test.rule, err, test.err)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	req := &Request{
+		Ip:    "81.2.69.142",
+		Path:  "/rancher-catalog.git/info/refs?service=git-upload-pack",
+		Agent: "Jenkins/2.235 git/2.17.1",
+		Uid:   "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f",
+	}
+
+	tests := []struct {
+		rule  Rule
+		match bool
+	}{
+		{Rule{Action: ruleDrop, Agent: "(?i)jenkins"}, true},
+		{Rule{Action: ruleDrop, Agent: "(?i)travis"}, false},
+		{Rule{Action: ruleDrop, Path: `\.git/info/refs`}, true},
+		{Rule{Action: ruleDrop, Uid: "^6cbc"}, true},
+		{Rule{Action: ruleDrop, Ip: []string{"81.2.69.0/24"}}, true},
+		{Rule{Action: ruleDrop, Ip: []string{"10.0.0.0/8", "81.2.69.142"}}, true},
+		{Rule{Action: ruleDrop, Ip: []string{"81.2.70.0/24"}}, false},
+		{Rule{Action: ruleDrop, Agent: "(?i)jenkins", Ip: []string{"81.2.69.0/24"}}, true},
+		{Rule{Action: ruleDrop, Agent: "(?i)jenkins", Ip: []string{"10.0.0.0/8"}}, false},
+		{Rule{Action: ruleDrop, Agent: "(?i)jenkins", Uid: "^0000"}, false},
+	}
+
+	for _, test := range tests {
+		rule := test.rule
+		if err := rule.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if match := rule.match(req); match != test.match {
+			t.Errorf("match(%+v) = %v, want %v", test.rule, match, test.match)
+		}
+	}
+
+	rule := Rule{Action: ruleDrop, Ip: []string{"0.0.0.0/0"}}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.match(&Request{Ip: "-"}) {
+		t.Errorf("match invalid ip, want no match")
+	}
+}
+
+func writeRules(t *testing.T, f, rules string) {
+	if err := ioutil.WriteFile(f, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRulesApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "rules.json")
+	writeRules(t, f, `[
+		{"action": "tag", "tag": "ci", "agent": "(?i)jenkins"},
+		{"action": "drop", "agent": "(?i)bot"},
+		{"action": "tag", "tag": "internal", "ip": ["10.0.0.0/8"]}
+	]`)
+	r, err := newRules(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		req    Request
+		keep   bool
+		filter string
+	}{
+		{Request{Agent: "Jenkins", Ip: "10.0.0.1"}, true, "ci"},
+		{Request{Agent: "Jenkins bot", Ip: "10.0.0.1"}, true, "ci"},
+		{Request{Agent: "Googlebot", Ip: "10.0.0.1"}, false, ""},
+		{Request{Agent: "git/2.17.1", Ip: "10.0.0.1"}, true, "internal"},
+		{Request{Agent: "git/2.17.1", Ip: "81.2.69.142"}, true, ""},
+	}
+
+	for _, test := range tests {
+		req := test.req
+		keep := r.apply(&req)
+		if keep != test.keep || req.Filter != test.filter {
+			t.Errorf("apply(%+v) = %v with filter %q, want %v with filter %q", test.req, keep, req.Filter, test.keep, test.filter)
+		}
+	}
+
+	// Invalid rules keep the current ones
+	writeRules(t, f, `[{"action": "drop"}]`)
+	os.Chtimes(f, time.Now(), time.Now().Add(time.Minute))
+	r.reload()
+	if req := (Request{Agent: "Googlebot"}); r.apply(&req) {
+		t.Errorf("apply after invalid reload, want current rules")
+	}
+
+	writeRules(t, f, `[{"action": "tag", "tag": "bot", "agent": "(?i)bot"}]`)
+	os.Chtimes(f, time.Now(), time.Now().Add(2*time.Minute))
+	r.reload()
+	if req := (Request{Agent: "Googlebot"}); !r.apply(&req) || req.Filter != "bot" {
+		t.Errorf("apply after reload, want reloaded rules")
+	}
+
+	if _, err := newRules(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("newRules of a missing file expected error")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	schemaMeasurement = "requests"
+	schemaTags        = "host,ip,uid,method,path,status,city,country,country_isocode,client,rancher_version,os,catalog,chart,chart_version,operation,filter,invalid"
+	schemaFields      = "ip,uid"
+)
+
+// Request attributes available to the schema
+var schemaAttributes = map[string]func(r *Request) string{
+	"host":            func(r *Request) string { return r.Host },
+	"ip":              func(r *Request) string { return r.Ip },
+	"uid":             func(r *Request) string { return r.Uid },
+	"method":          func(r *Request) string { return r.Method },
+	"proto":           func(r *Request) string { return r.Proto },
+	"path":            func(r *Request) string { return r.Catalog.Path },
+	"raw_path":        func(r *Request) string { return r.Path },
+	"status":          func(r *Request) string { return r.Status },
+	"referer":         func(r *Request) string { return r.Referer },
+	"agent":           func(r *Request) string { return r.Agent },
+	"log_format":      func(r *Request) string { return r.Format },
+	"city":            func(r *Request) string { return r.Location.City },
+	"country":         func(r *Request) string { return r.Location.Country.Name },
+	"country_isocode": func(r *Request) string { return r.Location.Country.ISOCode },
+	"client":          func(r *Request) string { return r.Client.Type },
+	"client_version":  func(r *Request) string { return r.Client.Version },
+	"rancher_version": func(r *Request) string { return r.Client.RancherVersion },
+	"os":              func(r *Request) string { return r.Client.OS },
+	"catalog":         func(r *Request) string { return r.Catalog.Catalog },
+	"chart":           func(r *Request) string { return r.Catalog.Chart },
+	"chart_version":   func(r *Request) string { return r.Catalog.ChartVersion },
+	"operation":       func(r *Request) string { return r.Catalog.Operation },
+	"filter":          func(r *Request) string { return r.Filter },
+	"invalid":         func(r *Request) string { return r.Invalid },
+	"source":          func(r *Request) string { return r.Source.File },
+}
+
+// Schema declares the influx measurement, and the request attributes written as tags or fields.
+// Attributes not declared are dropped
+type Schema struct {
+	Measurement string
+	Tags        []string
+	Fields      []string
+}
+
+func newSchema(measurement, tags, fields string) (*Schema, error) {
+	var s = &Schema{
+		Measurement: strings.TrimSpace(measurement),
+	}
+
+	if len(s.Measurement) == 0 {
+		return nil, fmt.Errorf("measurement is empty")
+	}
+
+	var err error
+	if s.Tags, err = schemaList(tags); err != nil {
+		return nil, fmt.Errorf("tags: %v", err)
+	}
+	if s.Fields, err = schemaList(fields); err != nil {
+		return nil, fmt.Errorf("fields: %v", err)
+	}
+	if len(s.Fields) == 0 {
+		return nil, fmt.Errorf("at least one field is required")
+	}
+
+	return s, nil
+}
+
+// Parse comma separated attributes
+func schemaList(s string) ([]string, error) {
+	list := []string{}
+	seen := map[string]bool{}
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if len(a) == 0 || seen[a] {
+			continue
+		}
+		if _, ok := schemaAttributes[a]; !ok {
+			return nil, fmt.Errorf("unknown attribute %s, available: %s", a, strings.Join(schemaAttributeNames(), " | "))
+		}
+		seen[a] = true
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+func schemaAttributeNames() []string {
+	names := make([]string, 0, len(schemaAttributes))
+	for name := range schemaAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Schema) tags(r *Request) map[string]string {
+	t := make(map[string]string, len(s.Tags))
+	for _, a := range s.Tags {
+		t[a] = schemaAttributes[a](r)
+	}
+	return t
+}
+
+func (s *Schema) fields(r *Request) map[string]interface{} {
+	v := make(map[string]interface{}, len(s.Fields))
+	for _, a := range s.Fields {
+		v[a] = schemaAttributes[a](r)
+	}
+	return v
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sinkInflux     = "influx"
+	sinkPrometheus = "prometheus"
+	sinkStdout     = "stdout"
+	sinkJson       = "json"
+)
+
+// Output sink, fed with the requests of every file
+type Sink struct {
+	Kind    string
+	name    string    // As set in outputs
+	format  string    // stdout format, influx or json
+	file    *JsonFile // json file, shared by file writers
+	dropped uint64    // Requests dropped while the sink was full, with several best effort sinks
+}
+
+// Durable sinks, influx and json file, are fed with backpressure. Best effort sinks, stdout and prometheus,
+// drop requests while full
+func (s *Sink) durable() bool {
+	return s.Kind == sinkInflux || s.Kind == sinkJson
+}
+
+// Parse sinks, comma separated. influx | prometheus | stdout[:influx|json] | json:<file>
+func newSinks(s, format string) ([]*Sink, error) {
+	var sinks []*Sink
+	kinds := map[string]bool{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+		kv := strings.SplitN(spec, ":", 2)
+		sink := &Sink{Kind: kv[0], name: spec}
+		switch sink.Kind {
+		case sinkInflux, sinkPrometheus:
+			if len(kv) > 1 {
+				return nil, fmt.Errorf("sink %s doesn't accept options", spec)
+			}
+		case sinkStdout:
+			sink.format = format
+			if sink.format != formatInflux {
+				sink.format = formatJson
+			}
+			if len(kv) > 1 {
+				sink.format = kv[1]
+			}
+			if sink.format != formatInflux && sink.format != formatJson {
+				return nil, fmt.Errorf("sink %s format should be %s | %s", spec, formatInflux, formatJson)
+			}
+		case sinkJson:
+			if len(kv) < 2 || len(kv[1]) == 0 {
+				return nil, fmt.Errorf("sink %s requires a file, %s:<file>", spec, sinkJson)
+			}
+			sink.file = &JsonFile{path: kv[1]}
+		default:
+			return nil, fmt.Errorf("unknown sink %s", spec)
+		}
+		if kinds[spec] {
+			return nil, fmt.Errorf("duplicated sink %s", spec)
+		}
+		kinds[spec] = true
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("at least one sink is required")
+	}
+
+	return sinks, nil
+}
+
+// Check a sink kind is enabled
+func hasSink(sinks []*Sink, kind string) bool {
+	for _, s := range sinks {
+		if s.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Get requests dropped by sink name
+func sinksDropped(sinks []*Sink) map[string]uint64 {
+	dropped := make(map[string]uint64, len(sinks))
+	for _, s := range sinks {
+		dropped[s.name] = atomic.LoadUint64(&s.dropped)
+	}
+	return dropped
+}
+
+// Append only json lines file, reopened after a write error
+type JsonFile struct {
+	sync.Mutex
+	path string
+	out  *os.File
+}
+
+func (j *JsonFile) write(reqs []*Request) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, req := range reqs {
+		if err := enc.Encode(req); err != nil {
+			return err
+		}
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.out == nil {
+		out, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		j.out = out
+	}
+
+	if _, err := j.out.Write(buf.Bytes()); err != nil {
+		j.out.Close()
+		j.out = nil
+		return err
+	}
+	return nil
+}
+
+func (j *JsonFile) Close() {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.out != nil {
+		j.out.Close()
+		j.out = nil
+	}
+}
+
+// Write requests to a json file by limit or by refresh. Batches failing are dropped
+func (r *Requests) sendToJsonFile(data chan *Request, j *JsonFile) {
+	var reqs []*Request
+
+	flush := func() {
+		if len(reqs) == 0 {
+			return
+		}
+		if err := j.write(reqs); err != nil {
+			log.Errorf("Writing json file %s, dropping %d requests: %v", j.path, len(reqs), err)
+		}
+		reqs = nil
+	}
+	defer flush()
+
+	ticker := time.NewTicker(time.Second * time.Duration(r.Config.refresh))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			flush()
+		case req, ok := <-data:
+			if !ok {
+				return
+			}
+			reqs = append(reqs, req)
+			if len(reqs) == r.Config.limit {
+				flush()
+			}
+		}
+	}
+}
+
+func (r *Requests) runSink(s *Sink, data chan *Request, st *fileStatus) {
+	switch s.Kind {
+	case sinkStdout:
+		r.print(data, s.format)
+	case sinkPrometheus:
+		r.sendToPrometheus(data)
+	case sinkJson:
+		r.sendToJsonFile(data, s.file)
+	default:
+		r.sendToInflux(data, st)
+	}
+}
+
+// Fan out requests to every sink, each one with its own writer and queue. Sinks that fail are skipped, returning when all failed.
+// A full durable sink, like a reconnecting influx, blocks the reader, so no request is lost nor committed unsent.
+// A full best effort sink doesn't block the others, its requests are dropped and counted
+func (r *Requests) fanOut(data chan *Request, st *fileStatus) {
+	sinks := r.Config.sinks
+	if len(sinks) == 1 {
+		r.runSink(sinks[0], data, st)
+		return
+	}
+
+	var wg sync.WaitGroup
+	outs := make([]chan *Request, len(sinks))
+	done := make([]chan struct{}, len(sinks))
+	for index, s := range sinks {
+		outs[index] = make(chan *Request, r.Config.limit)
+		done[index] = make(chan struct{})
+		wg.Add(1)
+		go func(s *Sink, out chan *Request, done chan struct{}) {
+			defer wg.Done()
+			defer close(done)
+			defer log.Debug("Closed sink ", s.Kind)
+			r.runSink(s, out, st)
+		}(s, outs[index], done[index])
+	}
+
+	defer wg.Wait()
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+
+	full := make([]bool, len(sinks))
+	for req := range data {
+		running := 0
+		for index := range outs {
+			select {
+			case <-done[index]:
+				continue
+			default:
+			}
+			running++
+			if sinks[index].durable() {
+				select {
+				case outs[index] <- req:
+				case <-done[index]:
+				}
+				continue
+			}
+			select {
+			case outs[index] <- req:
+				full[index] = false
+			default:
+				atomic.AddUint64(&sinks[index].dropped, 1)
+				if !full[index] {
+					log.Warnf("Sink %s is full, dropping requests of %s", sinks[index].name, req.Source.File)
+					full[index] = true
+				}
+			}
+		}
+		if running == 0 {
+			log.Error("All sinks failed")
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake influx v1 api, answering writes with status after delay, and recording the lines written
+type testInflux struct {
+	sync.Mutex
+	*httptest.Server
+	delay  time.Duration
+	status int
+	lines  []string
+}
+
+func newTestInflux(delay time.Duration) *testInflux {
+	i := &testInflux{delay: delay, status: http.StatusNoContent}
+	i.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/ping":
+			w.WriteHeader(http.StatusNoContent)
+		case "/query":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+		case "/write":
+			time.Sleep(i.delay)
+			body, _ := ioutil.ReadAll(req.Body)
+			i.Lock()
+			defer i.Unlock()
+			if i.status != http.StatusNoContent {
+				w.WriteHeader(i.status)
+				w.Write([]byte(`{"error":"test"}`))
+				return
+			}
+			for _, line := range strings.Split(string(body), "\n") {
+				if len(line) > 0 {
+					i.lines = append(i.lines, line)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return i
+}
+
+func (i *testInflux) setStatus(status int) {
+	i.Lock()
+	i.status = status
+	i.Unlock()
+}
+
+func (i *testInflux) written() []string {
+	i.Lock()
+	defer i.Unlock()
+	return append([]string{}, i.lines...)
+}
+
+// Requests writing to a test influx, with the default schema
+func newTestRequests(t *testing.T, url string) *Requests {
+	schema, err := newSchema(schemaMeasurement, schemaTags, schemaFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Requests{
+		Status:   newStatus(),
+		Internal: newInternal(nil),
+		Config: Params{
+			influxurl:     url,
+			influxdb:      "test",
+			influxversion: influxV1,
+			schema:        schema,
+			limit:         50,
+			refresh:       1,
+		},
+	}
+}
+
+func newTestRequest(n int) *Request {
+	return &Request{
+		Ip:        "81.2.69.142",
+		Uid:       "6cbcd9a0-3a1c-4f5a-9b7e-5f2f0c1d1e2f",
+		Host:      "git.rancher.io",
+		Path:      "/rancher-catalog.git/info/refs",
+		Source:    reqSource{File: "access.log", Inode: 1, Offset: int64(n+1) * 100},
+		Timestamp: time.Unix(1491289498+int64(n), 0).UTC(),
+	}
+}
+
+func TestFanOutSlowInflux(t *testing.T) {
+	const lines = 2000
+
+	i := newTestInflux(20 * time.Millisecond)
+	defer i.Close()
+
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newTestRequests(t, i.URL)
+	jsonFile := filepath.Join(dir, "requests.json")
+	if r.Config.sinks, err = newSinks(sinkInflux+","+sinkJson+":"+jsonFile, formatInflux); err != nil {
+		t.Fatal(err)
+	}
+	if r.State, err = newState(filepath.Join(dir, "state.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make(chan *Request, 1)
+	go func() {
+		for n := 0; n < lines; n++ {
+			data <- newTestRequest(n)
+		}
+		close(data)
+	}()
+	r.fanOut(data, r.Status.file("access.log"))
+	r.Config.sinks[1].file.Close()
+
+	if written := len(i.written()); written != lines {
+		t.Errorf("influx got %d lines, want %d", written, lines)
+	}
+
+	file, err := os.Open(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	count := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		count++
+	}
+	if count != lines {
+		t.Errorf("json file got %d lines, want %d", count, lines)
+	}
+
+	if offset, _ := r.State.get("access.log", 1); offset != newTestRequest(lines-1).Source.Offset {
+		t.Errorf("committed offset %d, want %d", offset, newTestRequest(lines-1).Source.Offset)
+	}
+	for name, dropped := range sinksDropped(r.Config.sinks) {
+		if dropped > 0 {
+			t.Errorf("sink %s dropped %d requests", name, dropped)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spoolExt      = ".lp"
+	spoolRejected = "rejected"
+)
+
+// Spool persists unsent batches as line protocol files, replayed in order once influx is reachable
+type Spool struct {
+	sync.Mutex
+	dir   string
+	max   int64
+	size  int64
+	files []string
+	seq   int
+}
+
+func newSpool(dir string, max int64) (*Spool, error) {
+	var s = &Spool{
+		dir: dir,
+		max: max,
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("creating spool dir %s: %v", dir, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		fileInfo, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, f)
+		s.size += fileInfo.Size()
+	}
+
+	if len(s.files) > 0 {
+		log.Infof("Spool %s has %d batches, %d bytes pending", dir, len(s.files), s.size)
+	}
+
+	return s, nil
+}
+
+// Persist a batch, dropping the oldest batches if spool size exceeds max
+func (s *Spool) push(m []influx.Point) error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(m))
+	for index := range m {
+		lines[index] = m[index].String()
+	}
+	data := []byte(strings.Join(lines, "\n") + "\n")
+
+	s.Lock()
+	defer s.Unlock()
+
+	for len(s.files) > 0 && s.size+int64(len(data)) > s.max {
+		log.Warnf("Spool %s is full, dropping batch %s", s.dir, s.files[0])
+		s.shift()
+	}
+
+	s.seq++
+	f := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolExt))
+	tmp := f + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("writing spool file %s: %v", f, err)
+	}
+	if err := os.Rename(tmp, f); err != nil {
+		return fmt.Errorf("writing spool file %s: %v", f, err)
+	}
+
+	s.files = append(s.files, f)
+	s.size += int64(len(data))
+	log.Infof("Spooled %d points to %s", len(m), f)
+
+	return nil
+}
+
+// Send spooled batches in order, stopping on first retryable failure. Batches influx rejects, or that can't be parsed,
+// are moved to the rejected dir. Returns true if spool is empty
+func (s *Spool) replay(send func([]influx.Point) error) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for len(s.files) > 0 {
+		f := s.files[0]
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			log.Errorf("Reading spool file %s, dropping: %v", f, err)
+			s.shift()
+			continue
+		}
+
+		pts, err := models.ParsePoints(data)
+		if err != nil {
+			log.Errorf("Parsing spool file %s: %v", f, err)
+			s.quarantine()
+			continue
+		}
+
+		points := make([]influx.Point, len(pts))
+		for index := range pts {
+			points[index] = *influx.NewPointFrom(pts[index])
+		}
+
+		if err := send(points); err != nil {
+			log.Errorf("Replaying spool file %s: %v", f, err)
+			if isRetryable(err) {
+				return false
+			}
+			s.quarantine()
+			continue
+		}
+
+		log.Infof("Replayed %d points from %s", len(points), f)
+		s.shift()
+	}
+
+	return true
+}
+
+// Persist a batch influx rejected to the rejected dir, out of the replay queue
+func (s *Spool) reject(m []influx.Point) error {
+	lines := make([]string, len(m))
+	for index := range m {
+		lines[index] = m[index].String()
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	dir := filepath.Join(s.dir, spoolRejected)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("creating spool rejected dir %s: %v", dir, err)
+	}
+	s.seq++
+	f := filepath.Join(dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolExt))
+	if err := ioutil.WriteFile(f, []byte(strings.Join(lines, "\n")+"\n"), 0640); err != nil {
+		return fmt.Errorf("writing spool rejected file %s: %v", f, err)
+	}
+	log.Warnf("Rejected %d points saved to %s", len(m), f)
+	return nil
+}
+
+// Move the oldest batch to the rejected dir. If it can't be moved, it's kept in place until restart
+func (s *Spool) quarantine() {
+	f := s.files[0]
+	if fileInfo, err := os.Stat(f); err == nil {
+		s.size -= fileInfo.Size()
+	}
+	s.files = s.files[1:]
+
+	dir := filepath.Join(s.dir, spoolRejected)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.Error("[Error]: ", err)
+		return
+	}
+	if err := os.Rename(f, filepath.Join(dir, filepath.Base(f))); err != nil {
+		log.Error("[Error]: ", err)
+		return
+	}
+	log.Warnf("Spool file %s moved to %s", f, dir)
+}
+
+// Remove the oldest batch
+func (s *Spool) shift() {
+	f := s.files[0]
+	fileInfo, err := os.Stat(f)
+	if err == nil {
+		s.size -= fileInfo.Size()
+	}
+	if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+		log.Error("[Error]: ", err)
+	}
+	s.files = s.files[1:]
+}
+
+// Get spool backlog
+func (s *Spool) Len() (int, int64) {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.files), s.size
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Writer answering writes with the next error, recording the points sent
+type testWriter struct {
+	errs []error
+	sent [][]influx.Point
+}
+
+func (w *testWriter) Check(retry int) bool { return true }
+
+func (w *testWriter) CheckConnect(interval int, stop chan struct{}) chan bool { return make(chan bool) }
+
+func (w *testWriter) Close() {}
+
+func (w *testWriter) Latency() time.Duration { return time.Millisecond }
+
+func (w *testWriter) sendToInflux(m []influx.Point, retry int) error {
+	var err error
+	if len(w.errs) > 0 {
+		err, w.errs = w.errs[0], w.errs[1:]
+	}
+	if err == nil {
+		w.sent = append(w.sent, m)
+	}
+	return err
+}
+
+var errTestUnreachable = errors.New("influx unreachable")
+
+// Batch of points with the batch number as a field
+func newTestBatch(batch, points int) []influx.Point {
+	m := make([]influx.Point, points)
+	for index := range m {
+		pt, _ := influx.NewPoint("requests", map[string]string{"path": "/index.yaml"}, map[string]interface{}{"batch": int64(batch)}, time.Unix(1491289498+int64(index), 0))
+		m[index] = *pt
+	}
+	return m
+}
+
+func batchOf(m []influx.Point) int64 {
+	fields, _ := m[0].Fields()
+	return fields["batch"].(int64)
+}
+
+func newTestSpoolDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := newTestSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for batch := 1; batch <= 3; batch++ {
+		if err := s.push(newTestBatch(batch, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := s.Len(); files != 3 {
+		t.Fatalf("got %d spooled batches, want 3", files)
+	}
+
+	// Pending batches are found again on restart
+	s, err = newSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &testWriter{errs: []error{nil, errTestUnreachable}}
+	send := func(m []influx.Point) error { return w.sendToInflux(m, 0) }
+	if s.replay(send) {
+		t.Errorf("replay with unreachable influx, want not empty")
+	}
+	if files, _ := s.Len(); files != 2 {
+		t.Errorf("got %d spooled batches after failure, want 2", files)
+	}
+	if !s.replay(send) {
+		t.Errorf("replay with influx recovered, want empty")
+	}
+
+	var order []int64
+	for _, m := range w.sent {
+		if len(m) != 10 {
+			t.Errorf("replayed batch %d has %d points, want 10", batchOf(m), len(m))
+		}
+		order = append(order, batchOf(m))
+	}
+	if fmt.Sprint(order) != "[1 2 3]" {
+		t.Errorf("replayed batches %v, want [1 2 3]", order)
+	}
+	if files, size := s.Len(); files != 0 || size != 0 {
+		t.Errorf("got %d batches, %d bytes after replay, want empty", files, size)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolExt)); len(files) != 0 {
+		t.Errorf("got spool files %v after replay, want removed", files)
+	}
+}
+
+func TestSpoolMax(t *testing.T) {
+	dir := newTestSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	size := int64(len(newTestBatch(0, 10)[0].String())+1) * 10
+	s, err := newSpool(dir, 3*size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for batch := 1; batch <= 5; batch++ {
+		if err := s.push(newTestBatch(batch, 10)); err != nil {
+			t.Fatal(err)
+		}
+		if _, spooled := s.Len(); spooled > 3*size {
+			t.Errorf("spool size %d over max %d", spooled, 3*size)
+		}
+	}
+
+	w := &testWriter{}
+	s.replay(func(m []influx.Point) error { return w.sendToInflux(m, 0) })
+	var order []int64
+	for _, m := range w.sent {
+		order = append(order, batchOf(m))
+	}
+	if fmt.Sprint(order) != "[3 4 5]" {
+		t.Errorf("replayed batches %v, want oldest dropped [3 4 5]", order)
+	}
+}
+
+func TestSpoolQuarantine(t *testing.T) {
+	dir := newTestSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for batch := 1; batch <= 3; batch++ {
+		if err := s.push(newTestBatch(batch, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A corrupt batch is quarantined too
+	if err := ioutil.WriteFile(filepath.Join(dir, "99999999999999999999-000000"+spoolExt), []byte("garbage\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = newSpool(dir, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := &writeError{status: "400 Bad Request", code: http.StatusBadRequest, body: "field type conflict"}
+	w := &testWriter{errs: []error{nil, rejected}}
+	if !s.replay(func(m []influx.Point) error { return w.sendToInflux(m, 0) }) {
+		t.Errorf("replay with rejected batches, want empty")
+	}
+
+	var order []int64
+	for _, m := range w.sent {
+		order = append(order, batchOf(m))
+	}
+	if fmt.Sprint(order) != "[1 3]" {
+		t.Errorf("replayed batches %v, want [1 3]", order)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, spoolRejected, "*"+spoolExt)); len(files) != 2 {
+		t.Errorf("got rejected files %v, want 2", files)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{errTestUnreachable, true},
+		{errInfluxDisconnected, true},
+		{&writeError{code: http.StatusInternalServerError}, true},
+		{&writeError{code: http.StatusServiceUnavailable}, true},
+		{&writeError{code: http.StatusTooManyRequests}, true},
+		{&writeError{code: http.StatusBadRequest}, false},
+		{&writeError{code: http.StatusUnauthorized}, false},
+		{&writeError{code: http.StatusRequestEntityTooLarge}, false},
+	}
+
+	for _, test := range tests {
+		if retryable := isRetryable(test.err); retryable != test.retryable {
+			t.Errorf("isRetryable(%v) = %v, want %v", test.err, retryable, test.retryable)
+		}
+	}
+}
+
+func TestSendSpooled(t *testing.T) {
+	dir := newTestSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	r := newTestRequests(t, "")
+	var err error
+	if r.Spool, err = newSpool(dir, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := &writeError{status: "400 Bad Request", code: http.StatusBadRequest, body: "field type conflict"}
+	w := &testWriter{errs: []error{
+		errTestUnreachable, // Batch 1 spooled
+		errTestUnreachable, // Batch 2 spooled, failing to replay batch 1
+		nil, nil,           // Recovered, replaying batches 1 and 2
+		nil,      // Batch 3
+		rejected, // Batch 4 rejected
+	}}
+
+	for batch := 1; batch <= 4; batch++ {
+		if !r.send(w, newTestBatch(batch, 10)) {
+			t.Errorf("send batch %d failed, want spooled or sent", batch)
+		}
+	}
+
+	var order []int64
+	for _, m := range w.sent {
+		order = append(order, batchOf(m))
+	}
+	if fmt.Sprint(order) != "[1 2 3]" {
+		t.Errorf("sent batches %v, want [1 2 3]", order)
+	}
+	if files, _ := r.Spool.Len(); files != 0 {
+		t.Errorf("got %d spooled batches, want 0", files)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, spoolRejected, "*"+spoolExt)); len(files) != 1 {
+		t.Errorf("got rejected files %v, want 1", files)
+	}
+	if r.Internal.rejected != 10 {
+		t.Errorf("got %d rejected points, want 10", r.Internal.rejected)
+	}
+
+	// Without spool, failed batches aren't committed, rejected ones are
+	r.Spool = nil
+	w = &testWriter{errs: []error{errTestUnreachable, rejected}}
+	if r.send(w, newTestBatch(5, 10)) {
+		t.Errorf("send failed batch without spool, want not committed")
+	}
+	if !r.send(w, newTestBatch(6, 10)) {
+		t.Errorf("send rejected batch without spool, want committed")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Source of a request, log file and offset after the line
+type reqSource struct {
+	File   string
+	Inode  uint64
+	Offset int64
+	Stream bool // Not seekable, like stdin, fifo or syslog
+}
+
+type fileState struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// State persists the last committed offset by file path and inode
+type State struct {
+	sync.Mutex
+	file  string
+	Files map[string]fileState `json:"files"`
+}
+
+func newState(f string) (*State, error) {
+	var s = &State{
+		file:  f,
+		Files: map[string]fileState{},
+	}
+
+	data, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file %s: %v", f, err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %v", f, err)
+	}
+	if s.Files == nil {
+		s.Files = map[string]fileState{}
+	}
+
+	return s, nil
+}
+
+// Get the offset to resume the file from. Rotated files are found by inode
+func (s *State) get(f string, inode uint64) (int64, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if st, ok := s.Files[f]; ok && st.Inode == inode {
+		return st.Offset, true
+	}
+	if inode == 0 {
+		return 0, false
+	}
+	for _, st := range s.Files {
+		if st.Inode == inode {
+			return st.Offset, true
+		}
+	}
+	return 0, false
+}
+
+// Save the offset of the last line sent
+func (s *State) commit(src reqSource) error {
+	if len(src.File) == 0 {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Entries of a rotated file are replaced, the inode now belongs to this path
+	for f, st := range s.Files {
+		if f != src.File && st.Inode == src.Inode && src.Inode != 0 {
+			delete(s.Files, f)
+		}
+	}
+	s.Files[src.File] = fileState{Inode: src.Inode, Offset: src.Offset}
+
+	return s.save()
+}
+
+// Forget a file that no longer exists
+func (s *State) delete(f string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.Files[f]; !ok {
+		return nil
+	}
+	delete(s.Files, f)
+
+	return s.save()
+}
+
+func (s *State) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("writing state file %s: %v", s.file, err)
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		return fmt.Errorf("writing state file %s: %v", s.file, err)
+	}
+	return nil
+}
+
+// Tail logger recording when the file is reopened, rotated or truncated. Lines sent after that are from the new file
+type reopenLog struct {
+	sync.Mutex
+	at time.Time
+}
+
+func (l *reopenLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if strings.HasPrefix(msg, "Successfully reopened") {
+		l.Lock()
+		l.at = time.Now()
+		l.Unlock()
+	}
+	log.Debug(msg)
+	return len(p), nil
+}
+
+// Get last reopen time
+func (l *reopenLog) reopenedAt() time.Time {
+	l.Lock()
+	defer l.Unlock()
+	return l.at
+}
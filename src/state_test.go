@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStateDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestState(t *testing.T) {
+	dir := newTestStateDir(t)
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "state.json")
+
+	s, err := newState(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := []reqSource{
+		{File: "/var/log/nginx/access.log", Inode: 10, Offset: 100},
+		{File: "/var/log/nginx/access.log", Inode: 10, Offset: 200},
+		{File: "/var/log/nginx/other.log", Inode: 20, Offset: 300},
+		{File: "", Inode: 30, Offset: 400},
+	}
+	for _, src := range commits {
+		if err := s.commit(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Committed offsets survive a restart
+	if s, err = newState(f); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file   string
+		inode  uint64
+		offset int64
+		ok     bool
+	}{
+		{"/var/log/nginx/access.log", 10, 200, true},
+		{"/var/log/nginx/other.log", 20, 300, true},
+		// Rotated, found by inode
+		{"/var/log/nginx/access.log.1", 10, 200, true},
+		// New file with the same path
+		{"/var/log/nginx/access.log", 11, 0, false},
+		{"/var/log/nginx/unknown.log", 30, 0, false},
+		{"/var/log/nginx/unknown.log", 0, 0, false},
+	}
+	for _, test := range tests {
+		offset, ok := s.get(test.file, test.inode)
+		if offset != test.offset || ok != test.ok {
+			t.Errorf("get(%s, %d) = %d, %v, want %d, %v", test.file, test.inode, offset, ok, test.offset, test.ok)
+		}
+	}
+
+	// Committing the rotated file moves the inode to the new path
+	if err := s.commit(reqSource{File: "/var/log/nginx/access.log.1", Inode: 10, Offset: 250}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.delete("/var/log/nginx/other.log"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = newState(f); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]fileState{"/var/log/nginx/access.log.1": {Inode: 10, Offset: 250}}
+	if fmt.Sprint(s.Files) != fmt.Sprint(want) {
+		t.Errorf("state files %v, want %v", s.Files, want)
+	}
+
+	if err := ioutil.WriteFile(f, []byte("{\"files\":"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newState(f); err == nil {
+		t.Errorf("newState with corrupt file, expected error")
+	}
+}
+
+func TestReopenLog(t *testing.T) {
+	l := &reopenLog{}
+	l.Write([]byte("Re-opening moved/deleted file /var/log/nginx/access.log ...\n"))
+	if !l.reopenedAt().IsZero() {
+		t.Errorf("reopenedAt() = %v before reopen, want zero", l.reopenedAt())
+	}
+	before := time.Now()
+	l.Write([]byte("Successfully reopened /var/log/nginx/access.log\n"))
+	if at := l.reopenedAt(); at.Before(before) {
+		t.Errorf("reopenedAt() = %v, want after %v", at, before)
+	}
+}
+
+// Requests reading log files with v1 format, resuming from the state file
+func newTestFileRequests(t *testing.T, stateFile string, daemon bool) *Requests {
+	formats, err := newLogFormats(logFormatV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRequests(t, "")
+	r.Control = NewChannelList()
+	if r.Parser, err = newParser(formats, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.DeadLetter, err = newDeadLetter("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.State, err = newState(stateFile); err != nil {
+		t.Fatal(err)
+	}
+	r.Config.filesOld = "1h"
+	r.Config.daemon = daemon
+	r.Config.poll = daemon
+	return r
+}
+
+func testLogLine(p string) string {
+	return `[21/Mar/2016:02:33:29 +0000] git.rancher.io 10.0.0.1 81.2.69.142 "GET ` + p + ` HTTP/1.1" 200 1234 "-" "git/2.17.1" 0.010 0.010 "-"`
+}
+
+func testInode(t *testing.T, f string) uint64 {
+	fileInfo, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileInode(fileInfo)
+}
+
+func TestResumeFile(t *testing.T) {
+	dir := newTestStateDir(t)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "access.log")
+	lines := []string{testLogLine("/one.git/info/refs"), testLogLine("/two.git/info/refs"), testLogLine("/three.git/info/refs")}
+	if err := ioutil.WriteFile(f, []byte(lines[0]+"\n"+lines[1]+"\n"+lines[2]+"\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	inode := testInode(t, f)
+
+	r := newTestFileRequests(t, filepath.Join(dir, "state.json"), false)
+	first := int64(len(lines[0]) + 1)
+	if err := r.State.commit(reqSource{File: f, Inode: inode, Offset: first}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, _ := r.Control.Add(f)
+	go func() {
+		r.getDataByFile(f)
+		close(data)
+	}()
+
+	var got []reqSource
+	for req := range data {
+		got = append(got, req.Source)
+	}
+	second := first + int64(len(lines[1])+1)
+	want := []reqSource{
+		{File: f, Inode: inode, Offset: second},
+		{File: f, Inode: inode, Offset: second + int64(len(lines[2])+1)},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("resumed sources %v, want %v", got, want)
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	dir := newTestStateDir(t)
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "access.log")
+	old := testLogLine("/one.git/info/refs")
+	if err := ioutil.WriteFile(f, []byte(old+"\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	oldInode := testInode(t, f)
+
+	r := newTestFileRequests(t, filepath.Join(dir, "state.json"), true)
+	stop, data, _ := r.Control.Add(f)
+	done := make(chan struct{})
+	go func() {
+		r.getDataByFile(f)
+		close(done)
+	}()
+
+	next := func() reqSource {
+		select {
+		case req := <-data:
+			return req.Source
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout reading file")
+		}
+		return reqSource{}
+	}
+
+	src := next()
+	if want := (reqSource{File: f, Inode: oldInode, Offset: int64(len(old) + 1)}); src != want {
+		t.Errorf("source %v, want %v", src, want)
+	}
+	if err := r.State.commit(src); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated, the new file lines are offset from its start
+	if err := os.Rename(f, f+".1"); err != nil {
+		t.Fatal(err)
+	}
+	line := testLogLine("/rotated.git/info/refs")
+	if err := ioutil.WriteFile(f, []byte(line+"\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	newInode := testInode(t, f)
+
+	src = next()
+	if want := (reqSource{File: f, Inode: newInode, Offset: int64(len(line) + 1)}); src != want {
+		t.Errorf("source after rotation %v, want %v", src, want)
+	}
+
+	// The rotated file is still found by inode until the new one is committed
+	if offset, ok := r.State.get(f+".1", oldInode); !ok || offset != int64(len(old)+1) {
+		t.Errorf("get(%s, %d) = %d, %v, want %d, true", f+".1", oldInode, offset, ok, len(old)+1)
+	}
+	if _, ok := r.State.get(f, newInode); ok {
+		t.Errorf("get(%s, %d) found before commit", f, newInode)
+	}
+
+	stop <- struct{}{}
+	<-done
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// File progress, updated by its reader and writer
+type fileStatus struct {
+	offset    int64
+	committed int64
+	pending   int64
+}
+
+func (f *fileStatus) setOffset(offset int64) {
+	atomic.StoreInt64(&f.offset, offset)
+}
+
+func (f *fileStatus) setCommitted(offset int64) {
+	atomic.StoreInt64(&f.committed, offset)
+}
+
+func (f *fileStatus) setPending(pending int) {
+	atomic.StoreInt64(&f.pending, int64(pending))
+}
+
+type fileStatusJson struct {
+	File      string `json:"file"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	Committed int64  `json:"committed"`
+	Lag       int64  `json:"lag"`
+	Pending   int64  `json:"pending"`
+}
+
+type statusJson struct {
+	Started       time.Time        `json:"started"`
+	Files         []fileStatusJson `json:"files"`
+	Pending       int64            `json:"pending"`
+	Written       uint64           `json:"written"`
+	LastWrite     *time.Time       `json:"last_write,omitempty"`
+	LastError     string           `json:"last_error,omitempty"`
+	LastErrorTime *time.Time       `json:"last_error_time,omitempty"`
+	SpoolBatches  int              `json:"spool_batches"`
+	SpoolBytes    int64            `json:"spool_bytes"`
+	Ready         bool             `json:"ready"`
+}
+
+// Status of the daemon, exposed by http api
+type Status struct {
+	sync.Mutex
+	started       time.Time
+	files         map[string]*fileStatus
+	written       uint64
+	lastWrite     time.Time
+	lastError     error
+	lastErrorTime time.Time
+}
+
+func newStatus() *Status {
+	return &Status{
+		started: time.Now(),
+		files:   map[string]*fileStatus{},
+	}
+}
+
+// Get file status, adding it if not exists
+func (s *Status) file(f string) *fileStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	st, ok := s.files[f]
+	if !ok {
+		st = &fileStatus{}
+		s.files[f] = st
+	}
+	return st
+}
+
+func (s *Status) remove(f string) {
+	s.Lock()
+	delete(s.files, f)
+	s.Unlock()
+}
+
+// Record influx write result
+func (s *Status) write(points int, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err != nil {
+		s.lastError = err
+		s.lastErrorTime = time.Now()
+		return
+	}
+	s.written += uint64(points)
+	s.lastWrite = time.Now()
+}
+
+// Ready unless last influx write failed
+func (s *Status) ready() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.lastError == nil || s.lastWrite.After(s.lastErrorTime)
+}
+
+func (r *Requests) getStatus() statusJson {
+	s := r.Status
+	st := statusJson{
+		Files: []fileStatusJson{},
+		Ready: s.ready(),
+	}
+
+	s.Lock()
+	st.Started = s.started
+	st.Written = s.written
+	if !s.lastWrite.IsZero() {
+		lastWrite := s.lastWrite
+		st.LastWrite = &lastWrite
+	}
+	if s.lastError != nil {
+		lastErrorTime := s.lastErrorTime
+		st.LastError = s.lastError.Error()
+		st.LastErrorTime = &lastErrorTime
+	}
+	files := make(map[string]*fileStatus, len(s.files))
+	for f, fs := range s.files {
+		files[f] = fs
+	}
+	s.Unlock()
+
+	for f, fs := range files {
+		file := fileStatusJson{
+			File:      f,
+			Offset:    atomic.LoadInt64(&fs.offset),
+			Committed: atomic.LoadInt64(&fs.committed),
+			Pending:   atomic.LoadInt64(&fs.pending),
+		}
+		if fileInfo, err := os.Stat(f); err == nil {
+			file.Size = fileInfo.Size()
+			if file.Size > file.Offset {
+				file.Lag = file.Size - file.Offset
+			}
+		}
+		st.Pending += file.Pending
+		st.Files = append(st.Files, file)
+	}
+	sort.Slice(st.Files, func(i, j int) bool { return st.Files[i].File < st.Files[j].File })
+
+	if r.Spool != nil {
+		st.SpoolBatches, st.SpoolBytes = r.Spool.Len()
+	}
+
+	return st
+}
+
+func (r *Requests) serveStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(r.getStatus())
+}
+
+func (r *Requests) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (r *Requests) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	if !r.Status.ready() {
+		http.Error(w, "influx write failing", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	syslogChannel = "syslog"
+	syslogMaxSize = 64 * 1024
+)
+
+// Syslog listen address, udp or tcp
+type syslogListener struct {
+	network string
+	addr    string
+}
+
+// Parse syslog listen addresses, comma separated, e.g. udp://:5514,tcp://:5514. Udp if no scheme
+func newSyslogListeners(s string) ([]syslogListener, error) {
+	var listeners []syslogListener
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if len(addr) == 0 {
+			continue
+		}
+		l := syslogListener{network: "udp", addr: addr}
+		if i := strings.Index(addr, "://"); i >= 0 {
+			l.network, l.addr = addr[:i], addr[i+3:]
+		}
+		if l.network != "udp" && l.network != "tcp" {
+			return nil, fmt.Errorf("syslog %s network should be udp | tcp", addr)
+		}
+		if _, _, err := net.SplitHostPort(l.addr); err != nil {
+			return nil, fmt.Errorf("syslog %s: %v", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Get the sender hostname and the message payload of a RFC 5424 or RFC 3164 syslog message
+func parseSyslog(msg string) (host, payload string, err error) {
+	msg = strings.TrimRight(msg, "\r\n\x00")
+	if !strings.HasPrefix(msg, "<") {
+		return "", "", fmt.Errorf("missing syslog priority")
+	}
+	end := strings.Index(msg, ">")
+	if end < 2 || end > 4 {
+		return "", "", fmt.Errorf("bad syslog priority")
+	}
+	if _, err := strconv.Atoi(msg[1:end]); err != nil {
+		return "", "", fmt.Errorf("bad syslog priority")
+	}
+	msg = msg[end+1:]
+
+	// RFC 5424: VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	if strings.HasPrefix(msg, "1 ") {
+		parts := strings.SplitN(msg, " ", 7)
+		if len(parts) < 7 {
+			return "", "", fmt.Errorf("truncated RFC 5424 syslog header")
+		}
+		host = parts[2]
+		payload, err = skipStructuredData(parts[6])
+		if err != nil {
+			return "", "", err
+		}
+		payload = strings.TrimPrefix(payload, "\xef\xbb\xbf")
+		if host == "-" {
+			host = ""
+		}
+		return host, payload, nil
+	}
+
+	// RFC 3164: Mmm dd hh:mm:ss HOSTNAME TAG: MSG
+	if len(msg) < 16 || msg[3] != ' ' || msg[6] != ' ' || msg[9] != ':' || msg[12] != ':' || msg[15] != ' ' {
+		return "", "", fmt.Errorf("bad RFC 3164 syslog timestamp")
+	}
+	parts := strings.SplitN(msg[16:], " ", 2)
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("truncated RFC 3164 syslog header")
+	}
+	host = parts[0]
+	payload = parts[1]
+	if i := strings.Index(payload, ": "); i >= 0 && !strings.ContainsAny(payload[:i], " []") {
+		payload = payload[i+2:]
+	}
+	return host, payload, nil
+}
+
+// Skip RFC 5424 structured data, - or [id param="value"]...
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, "-") {
+		return strings.TrimPrefix(s[1:], " "), nil
+	}
+	for strings.HasPrefix(s, "[") {
+		escaped, quoted, end := false, false, -1
+		for i := 1; i < len(s) && end < 0; i++ {
+			switch {
+			case escaped:
+				escaped = false
+			case s[i] == '\\':
+				escaped = true
+			case s[i] == '"':
+				quoted = !quoted
+			case s[i] == ']' && !quoted:
+				end = i
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated RFC 5424 structured data")
+		}
+		s = s[end+1:]
+	}
+	return strings.TrimPrefix(s, " "), nil
+}
+
+// Read a syslog message from a tcp stream, octet counted (RFC 6587) or new line delimited
+func readSyslog(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		return reader.ReadString('\n')
+	}
+
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil || size <= 0 || size > syslogMaxSize {
+		return "", fmt.Errorf("bad syslog octet count %s", length)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+// Parse a syslog message and get its request, by sender source
+func (r *Requests) getSyslogData(msg, addr string, data chan *Request) {
+	host, payload, err := parseSyslog(msg)
+	if len(host) == 0 {
+		host = addr
+	}
+	src := reqSource{File: syslogChannel + "/" + host, Stream: true}
+	if err != nil {
+		r.DeadLetter.add(src, msg, newParseError(reasonBadSyslog, err))
+		return
+	}
+	r.getData(payload, src, data)
+}
+
+// Get data from syslog listeners until stop
+func (r *Requests) getDataBySyslog(listeners []syslogListener, stop chan struct{}, data chan *Request) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var closers []io.Closer
+	closed := false
+
+	// Track listeners and connections to close on stop
+	track := func(c io.Closer) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if closed {
+			return false
+		}
+		closers = append(closers, c)
+		return true
+	}
+
+	for _, l := range listeners {
+		if l.network == "udp" {
+			conn, err := net.ListenPacket(l.network, l.addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Info("Listening syslog on udp ", l.addr)
+			track(conn)
+			wg.Add(1)
+			go func(conn net.PacketConn) {
+				defer wg.Done()
+				buf := make([]byte, syslogMaxSize)
+				for {
+					n, addr, err := conn.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					host, _, _ := net.SplitHostPort(addr.String())
+					r.getSyslogData(string(buf[:n]), host, data)
+				}
+			}(conn)
+			continue
+		}
+
+		ln, err := net.Listen(l.network, l.addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Info("Listening syslog on tcp ", l.addr)
+		track(ln)
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				if !track(conn) {
+					conn.Close()
+					return
+				}
+				wg.Add(1)
+				go func(conn net.Conn) {
+					defer wg.Done()
+					defer conn.Close()
+					host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+					reader := bufio.NewReaderSize(conn, syslogMaxSize)
+					for {
+						msg, err := readSyslog(reader)
+						if len(strings.TrimSpace(msg)) > 0 {
+							r.getSyslogData(msg, host, data)
+						}
+						if err != nil {
+							if err != io.EOF {
+								log.Debugf("Reading syslog from %s: %v", host, err)
+							}
+							return
+						}
+					}
+				}(conn)
+			}
+		}(ln)
+	}
+
+	<-stop
+	mutex.Lock()
+	closed = true
+	for _, c := range closers {
+		c.Close()
+	}
+	mutex.Unlock()
+	wg.Wait()
+	log.Info("Closed syslog listeners")
+}
+
+// Start syslog reader and writer, registered in the channel list as a file
+func (r *Requests) getSyslogReaders(in, out *sync.WaitGroup) {
+	stop, data, err := r.Control.Add(syslogChannel)
+	if err != nil {
+		log.Error("Creating control channels ", syslogChannel)
+		return
+	}
+	st := r.Status.file(syslogChannel)
+
+	in.Add(1)
+	go func() {
+		defer in.Done()
+		defer r.Control.Delete(syslogChannel)
+		r.getDataBySyslog(r.Config.syslogListeners, stop, data)
+	}()
+
+	out.Add(1)
+	go func() {
+		defer out.Done()
+		defer r.Status.remove(syslogChannel)
+		r.getOutput(data, st)
+	}()
+}
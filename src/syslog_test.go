@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseSyslog(t *testing.T) {
+	const line = `81.2.69.142 - - [21/Mar/2016:02:33:29 +0000] "GET / HTTP/1.1" 200 0`
+	tests := []struct {
+		msg     string
+		host    string
+		payload string
+		err     bool
+	}{
+		{"<190>1 2016-03-21T02:33:29Z lb1 nginx - - - " + line, "lb1", line, false},
+		{"<190>1 2016-03-21T02:33:29Z lb1 nginx 12 access [meta a=\"b]\\\"\"][x@1 y=\"z\"] " + line + "\n", "lb1", line, false},
+		{"<190>1 2016-03-21T02:33:29Z - nginx - - - \xef\xbb\xbf" + line, "", line, false},
+		{"<190>Mar 21 02:33:29 lb1 nginx: " + line, "lb1", line, false},
+		{"<13>Mar  1 02:33:29 lb1 " + line + "\x00", "lb1", line, false},
+		{"<190>Mar 21 02:33:29 lb1 nginx[123]: " + line, "lb1", "nginx[123]: " + line, false},
+		{line, "", "", true},
+		{"<>1 2016-03-21T02:33:29Z lb1 nginx - - - " + line, "", "", true},
+		{"<1a>1 2016-03-21T02:33:29Z lb1 nginx - - - " + line, "", "", true},
+		{"<190>1 2016-03-21T02:33:29Z lb1 nginx", "", "", true},
+		{"<190>1 2016-03-21T02:33:29Z lb1 nginx - - [meta a=\"b\" GET /", "", "", true},
+		{"<190>21/Mar/2016 lb1 nginx: " + line, "", "", true},
+		{"<190>Mar 21 02:33:29 lb1", "", "", true},
+	}
+
+	for _, test := range tests {
+		host, payload, err := parseSyslog(test.msg)
+		if (err != nil) != test.err {
+			t.Errorf("parseSyslog(%q) error %v, want error %v", test.msg, err, test.err)
+			continue
+		}
+		if host != test.host || payload != test.payload {
+			t.Errorf("parseSyslog(%q) = %q, %q, want %q, %q", test.msg, host, payload, test.host, test.payload)
+		}
+	}
+}
+
+func TestReadSyslog(t *testing.T) {
+	tests := []struct {
+		stream string
+		msgs   []string
+		err    bool // Error other than EOF after the messages
+	}{
+		{"<13>1 - - - - - - a\n<13>1 - - - - - - b\n", []string{"<13>1 - - - - - - a\n", "<13>1 - - - - - - b\n"}, false},
+		{"19 <13>1 - - - - - - a20 <13>1 - - - - - - b\n", []string{"<13>1 - - - - - - a", "<13>1 - - - - - - b\n"}, false},
+		{"19 <13>1 - - - - - - a<13>1 - - - - - - b\n", []string{"<13>1 - - - - - - a", "<13>1 - - - - - - b\n"}, false},
+		{"30 <13>1 - - - - - - a", nil, true},
+		{"0 <13>1 - - - - - - a", nil, true},
+		{"99999999 <13>1 - - - - - - a", nil, true},
+	}
+
+	for _, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.stream))
+		var msgs []string
+		var err error
+		for {
+			var msg string
+			if msg, err = readSyslog(reader); err != nil {
+				break
+			}
+			msgs = append(msgs, msg)
+		}
+		if (err != io.EOF) != test.err {
+			t.Errorf("readSyslog(%q) error %v, want error %v", test.stream, err, test.err)
+		}
+		if strings.Join(msgs, "|") != strings.Join(test.msgs, "|") {
+			t.Errorf("readSyslog(%q) = %q, want %q", test.stream, msgs, test.msgs)
+		}
+	}
+}